@@ -25,6 +25,28 @@ const (
 	CompositorTypeHyprland CompositorType = "hyprland"
 )
 
+// GetActiveWindowManagerByName returns the backend selected in the config,
+// "auto" (or an empty name) falls back to detection by OS and compositor
+func GetActiveWindowManagerByName(name string) (
+	ActiveWindowManagerInterface,
+	error,
+) {
+	switch name {
+	case "", "auto":
+		return GetActiveWindowManager()
+	case string(CompositorTypeNiri):
+		return niri.NewNiriActiveWindow(), nil
+	case string(CompositorTypeHyprland):
+		return hyprland.NewHyprlandActiveWindow(), nil
+	case "aerospace":
+		return macosaerospace.NewMacOsAerospaceActiveWindow(), nil
+	case "macos":
+		return macos.NewMacOsActiveWindow(), nil
+	}
+
+	return nil, errors.New("window manager backend is not supported: " + name)
+}
+
 func GetActiveWindowManager() (
 	ActiveWindowManagerInterface,
 	error,
@@ -1,120 +1,181 @@
 // Package aggregatemanager aggregates raw screen time records into
 // consolidated sessions based on application ID, window title, and time proximity.
+//
+// Raw records are processed in bounded batches. Every batch is stored in a
+// single transaction together with the high-water mark, so an interrupted
+// run never duplicates or loses data.
 package aggregatemanager
 
 import (
+	"context"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/probeldev/niri-screen-time/db"
 	"github.com/probeldev/niri-screen-time/model"
 )
 
-const aggregateInterval = 10 * time.Minute
+// batchSize - raw records aggregated in one transaction
+const batchSize = 5000
 
 type aggregateManager struct {
-	screenTimeDB db.ScreenTimeDB
-	aggregateDB  db.AggregatedScreenTimeDB
+	aggregatorDB db.SessionStore
+	mutex        sync.Mutex
+	interval     time.Duration
+	maxGap       time.Duration
 }
 
 func NewAggragetManager(
-	screenTimeDB db.ScreenTimeDB,
-	aggregateDB db.AggregatedScreenTimeDB,
+	aggregatorDB db.SessionStore,
+	interval time.Duration,
+	maxGap time.Duration,
 ) *aggregateManager {
 	am := &aggregateManager{}
-	am.screenTimeDB = screenTimeDB
-	am.aggregateDB = aggregateDB
+	am.aggregatorDB = aggregatorDB
+	am.interval = interval
+	am.maxGap = maxGap
 
 	return am
 }
 
-func (am *aggregateManager) Aggregate() {
+// SetSettings applies new settings starting with the next run
+func (am *aggregateManager) SetSettings(
+	interval time.Duration,
+	maxGap time.Duration,
+) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	am.interval = interval
+	am.maxGap = maxGap
+}
+
+func (am *aggregateManager) settings() (
+	interval time.Duration,
+	maxGap time.Duration,
+) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	return am.interval, am.maxGap
+}
+
+// Aggregate aggregates pending samples every interval until ctx is
+// canceled. A run that has started is finished first.
+func (am *aggregateManager) Aggregate(ctx context.Context) {
 	for {
 		am.aggregateWorker()
-		time.Sleep(aggregateInterval)
+
+		interval, _ := am.settings()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
 	}
 }
 
 func (am *aggregateManager) aggregateWorker() {
 	fn := "aggregateManager:aggregateWorker"
-	screenTimes, err := am.screenTimeDB.GetAll()
+	started := time.Now()
 
+	result, err := am.AggregatePending()
 	if err != nil {
 		log.Println(fn, err)
 		return
 	}
 
-	var aggregate model.AggregatedScreenTime
-	screenTimeForDelete := []model.ScreenTime{}
+	if result.Samples > 0 {
+		log.Printf("%s: %d samples aggregated into %d sessions in %v, %d pending",
+			fn, result.Samples, result.Sessions, time.Since(started).Round(time.Millisecond), result.Pending)
+	}
+}
 
-	for _, st := range screenTimes {
-		if len(screenTimeForDelete) == 0 {
-			aggregate = model.NewAggregatedScreenTimeFromScreenTime(st)
+// Result - samples aggregated by one run
+type Result struct {
+	Samples  int
+	Sessions int
+	// Pending - samples left for the next run
+	Pending int
+}
 
-			screenTimeForDelete = append(screenTimeForDelete, st)
-			continue
-		}
+// AggregatePending aggregates the pending samples once. A batch that is
+// committed stays committed when a later batch fails.
+func (am *aggregateManager) AggregatePending() (Result, error) {
+	var result Result
 
-		if am.needAggregate(aggregate, st) {
-			aggregate.AddScreenTime(st)
-			screenTimeForDelete = append(screenTimeForDelete, st)
-			continue
-		}
+	state, err := am.aggregatorDB.State()
+	if err != nil {
+		return result, err
+	}
 
-		err = am.aggregateDB.Insert(aggregate)
+	_, maxGap := am.settings()
+
+	lastID := state.LastID
+
+	for {
+		batch, err := am.aggregatorDB.NextBatch(lastID, batchSize)
 		if err != nil {
-			log.Println(fn, err)
-			return
+			return result, err
 		}
 
-		for _, std := range screenTimeForDelete {
-			err = am.screenTimeDB.DeleteByID(std)
-			if err != nil {
-				log.Println(fn, err)
-				return
-			}
+		if len(batch) == 0 {
+			break
 		}
 
-		aggregate = model.NewAggregatedScreenTimeFromScreenTime(st)
-		screenTimeForDelete = []model.ScreenTime{st}
-	}
+		// the last session of a full batch may continue in the next one
+		full := len(batch) == batchSize
+		aggregates, consumed := am.buildSessions(batch, maxGap, full)
 
-	if len(screenTimeForDelete) > 0 {
-		err = am.aggregateDB.Insert(aggregate)
-		if err != nil {
-			log.Println(fn, err)
-			return
+		batchLastID := batch[consumed-1].ID
+		if err := am.aggregatorDB.CommitBatch(aggregates, lastID, batchLastID); err != nil {
+			return result, err
 		}
 
-		for _, std := range screenTimeForDelete {
-			err := am.screenTimeDB.DeleteByID(std)
-			if err != nil {
-				log.Println(fn, err)
-				return
-			}
+		lastID = batchLastID
+		result.Samples += consumed
+		result.Sessions += len(aggregates)
+
+		if !full {
+			break
 		}
 	}
-}
 
-func (*aggregateManager) needAggregate(
-	aggregate model.AggregatedScreenTime,
-	screenTime model.ScreenTime,
-) bool {
-	if aggregate.AppID != screenTime.AppID {
-		return false
-	}
+	result.Pending = state.Pending - result.Samples
 
-	if aggregate.Title != screenTime.Title {
-		return false
-	}
+	return result, nil
+}
 
-	if screenTime.Date.Sub(aggregate.Date) > time.Second {
-		return false
+// buildSessions merges consecutive records of batch into sessions and
+// returns how many records were used. With holdLast the trailing session
+// is left for the next batch, unless it spans the whole batch.
+func (am *aggregateManager) buildSessions(
+	batch []model.ScreenTime,
+	maxGap time.Duration,
+	holdLast bool,
+) (
+	[]model.AggregatedScreenTime,
+	int,
+) {
+	sessions := []model.AggregatedScreenTime{}
+	aggregate := model.NewAggregatedScreenTimeFromScreenTime(batch[0])
+	sessionStart := 0
+
+	for i, st := range batch[1:] {
+		if aggregate.Continues(st, maxGap) {
+			aggregate.AddScreenTime(st)
+			continue
+		}
+
+		sessions = append(sessions, aggregate)
+		aggregate = model.NewAggregatedScreenTimeFromScreenTime(st)
+		sessionStart = i + 1
 	}
 
-	if aggregate.Date.Format("2006-01-02") != screenTime.Date.Format("2006-01-02") {
-		return false
+	if holdLast && sessionStart > 0 {
+		return sessions, sessionStart
 	}
 
-	return true
+	return append(sessions, aggregate), len(batch)
 }
@@ -0,0 +1,216 @@
+// Package backupmanager writes timestamped copies of the database on a
+// schedule and keeps only the newest of them.
+package backupmanager
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/db"
+)
+
+const (
+	timeLayout = "20060102-150405"
+	// pollInterval - how often the schedule and the settings are checked
+	pollInterval = time.Minute
+)
+
+type backupManager struct {
+	conn     *db.DBConnection
+	mutex    sync.Mutex
+	settings config.Backup
+}
+
+func NewBackupManager(
+	conn *db.DBConnection,
+	settings config.Backup,
+) *backupManager {
+	bm := &backupManager{}
+	bm.conn = conn
+	bm.settings = settings
+
+	return bm
+}
+
+// SetSettings applies new settings starting with the next check
+func (bm *backupManager) SetSettings(settings config.Backup) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	bm.settings = settings
+}
+
+func (bm *backupManager) getSettings() config.Backup {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	return bm.settings
+}
+
+// Run writes a backup whenever the newest one is older than the interval,
+// so restarting the daemon does not add extra copies
+// Run backs the database up on schedule until ctx is canceled. A backup
+// that has started is finished first.
+func (bm *backupManager) Run(ctx context.Context) {
+	for {
+		wait := bm.backupIfDue()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backupIfDue writes a backup if one is due and returns how long to wait
+// before the next check
+func (bm *backupManager) backupIfDue() time.Duration {
+	fn := "backupManager:backupIfDue"
+
+	settings := bm.getSettings()
+	if settings.Interval == 0 {
+		return pollInterval
+	}
+
+	wait, err := bm.untilDue(settings, time.Now())
+	if err != nil {
+		log.Println(fn, err)
+		wait = settings.Interval
+	}
+	if wait > 0 {
+		return min(wait, pollInterval)
+	}
+
+	path, removed, err := bm.Backup(settings, time.Now())
+	if err != nil {
+		log.Println(fn, err)
+		return settings.Interval
+	}
+	log.Printf("%s: backup saved to %s, %d old backups removed", fn, path, len(removed))
+
+	return 0
+}
+
+func (bm *backupManager) untilDue(settings config.Backup, now time.Time) (time.Duration, error) {
+	backups, err := bm.List(settings)
+	if err != nil || len(backups) == 0 {
+		return 0, err
+	}
+
+	last, err := time.ParseInLocation(timeLayout, backups[len(backups)-1].stamp, time.Local)
+	if err != nil {
+		return 0, err
+	}
+
+	return last.Add(settings.Interval).Sub(now), nil
+}
+
+// Backup writes a copy named after the database and now into the backup
+// directory and removes all but the settings.Keep newest copies
+func (bm *backupManager) Backup(settings config.Backup, now time.Time) (path string, removed []string, err error) {
+	dir, err := bm.Dir(settings)
+	if err != nil {
+		return "", nil, err
+	}
+
+	prefix, ext := bm.nameParts()
+	path = filepath.Join(dir, prefix+now.Format(timeLayout)+ext)
+
+	if err := bm.conn.Backup(path); err != nil {
+		return "", nil, err
+	}
+
+	removed, err = bm.rotate(settings)
+
+	return path, removed, err
+}
+
+// Dir returns the backup directory, "backups" next to the database by default
+func (bm *backupManager) Dir(settings config.Backup) (string, error) {
+	if settings.Dir == "" {
+		return filepath.Join(filepath.Dir(bm.conn.Path()), "backups"), nil
+	}
+
+	return db.ExpandHome(settings.Dir)
+}
+
+// File - a backup written by the manager
+type File struct {
+	Path  string
+	stamp string
+}
+
+// List returns the backups of this database, oldest first. Other files
+// in the directory are ignored.
+func (bm *backupManager) List(settings config.Backup) ([]File, error) {
+	dir, err := bm.Dir(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, ext := bm.nameParts()
+
+	var backups []File
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, ext); !ok {
+			continue
+		}
+		if _, err := time.Parse(timeLayout, stamp); err != nil {
+			continue
+		}
+
+		backups = append(backups, File{Path: filepath.Join(dir, entry.Name()), stamp: stamp})
+	}
+
+	slices.SortFunc(backups, func(a, b File) int {
+		return strings.Compare(a.stamp, b.stamp)
+	})
+
+	return backups, nil
+}
+
+func (bm *backupManager) rotate(settings config.Backup) ([]string, error) {
+	backups, err := bm.List(settings)
+	if err != nil || len(backups) <= settings.Keep {
+		return nil, err
+	}
+
+	var removed []string
+	for _, b := range backups[:len(backups)-settings.Keep] {
+		if err := os.Remove(b.Path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.Path)
+	}
+
+	return removed, nil
+}
+
+// nameParts splits the database file name: db.db gives "db-" and ".db"
+func (bm *backupManager) nameParts() (prefix, ext string) {
+	base := filepath.Base(bm.conn.Path())
+	ext = filepath.Ext(base)
+
+	return strings.TrimSuffix(base, ext) + "-", ext
+}
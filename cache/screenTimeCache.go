@@ -10,24 +10,86 @@ import (
 	"github.com/probeldev/niri-screen-time/model"
 )
 
+const (
+	// maxFailures - неудачные сбросы подряд, после которых записи остаются
+	// только в журнале и не копятся в памяти, пока база недоступна
+	maxFailures = 3
+	// maxRetryDelay - наибольшая пауза между попытками сохранить записи
+	maxRetryDelay = 5 * time.Minute
+	// journalBatch - записи журнала, сохраняемые за один раз
+	journalBatch = 5000
+	// maxMemoryRecords - предел буфера без журнала, самые старые записи
+	// сверх него отбрасываются
+	maxMemoryRecords = 100000
+)
+
+// Journal - копия буфера на диске, которая переживает падение демона
+// (см. db.SampleJournal)
+type Journal interface {
+	Append(st model.ScreenTime) error
+	Len() int
+	Sync() error
+	Next(limit int) ([]model.ScreenTime, int, error)
+	Drop(n int) error
+	Close() error
+}
+
 // ScreenTimeCache - буфер между сбором данных и их сохранением в БД
 type ScreenTimeCache struct {
-	db          *db.ScreenTimeDB
+	db          db.SampleStore
+	journal     Journal
 	buffer      []model.ScreenTime
+	journaled   int  // записи буфера, попавшие в журнал, они идут в нем по порядку
+	spilled     bool // записи только в журнале, буфер не используется
 	bufferMutex sync.Mutex
+	flushMutex  sync.Mutex // одновременно идет только один сброс, записи не перемешиваются
+	failures    int        // неудачные сбросы подряд, под flushMutex
+	retryAt     time.Time  // до этого момента сброс не повторяется, под flushMutex
 	flushPeriod time.Duration
 	maxBuffer   int
 	stopChan    chan struct{}
+	resetChan   chan struct{}
+	flushChan   chan struct{}
 }
 
 // NewScreenTimeCache создает новый кэш
-func NewScreenTimeCache(screentimedb *db.ScreenTimeDB, flushPeriod time.Duration, maxBuffer int) *ScreenTimeCache {
+func NewScreenTimeCache(screentimedb db.SampleStore, flushPeriod time.Duration, maxBuffer int) *ScreenTimeCache {
 	return &ScreenTimeCache{
 		db:          screentimedb,
 		buffer:      make([]model.ScreenTime, 0, maxBuffer),
 		flushPeriod: flushPeriod,
 		maxBuffer:   maxBuffer,
 		stopChan:    make(chan struct{}),
+		resetChan:   make(chan struct{}, 1),
+		flushChan:   make(chan struct{}, 1),
+	}
+}
+
+// SetJournal подключает журнал до Start: каждая запись буфера сначала
+// дописывается в него. Записи, оставшиеся в журнале от прошлого запуска,
+// сохраняются первыми. Кэш закрывает журнал в Stop.
+func (stc *ScreenTimeCache) SetJournal(journal Journal) {
+	stc.bufferMutex.Lock()
+	defer stc.bufferMutex.Unlock()
+
+	stc.journal = journal
+	if pending := journal.Len(); pending > 0 {
+		log.Printf("Replaying %d samples left in the journal", pending)
+		stc.spilled = true
+		stc.signalFlush()
+	}
+}
+
+// SetLimits меняет период сброса и размер буфера без потери накопленных данных
+func (stc *ScreenTimeCache) SetLimits(flushPeriod time.Duration, maxBuffer int) {
+	stc.bufferMutex.Lock()
+	stc.flushPeriod = flushPeriod
+	stc.maxBuffer = maxBuffer
+	stc.bufferMutex.Unlock()
+
+	select {
+	case stc.resetChan <- struct{}{}:
+	default:
 	}
 }
 
@@ -36,64 +98,226 @@ func (stc *ScreenTimeCache) Start() {
 	go stc.flushWorker()
 }
 
-// Stop останавливает фоновую горутину и сбрасывает оставшиеся данные
+// Stop останавливает фоновую горутину и сбрасывает оставшиеся данные.
+// Что не удалось сохранить, остается в журнале до следующего запуска.
 func (stc *ScreenTimeCache) Stop() {
 	close(stc.stopChan)
+
+	stc.flushMutex.Lock()
+	stc.retryAt = time.Time{}
+	stc.flushMutex.Unlock()
 	stc.flushBuffer() // Сброс оставшихся данных
+
+	if stc.journal != nil {
+		if err := stc.journal.Close(); err != nil {
+			log.Printf("Failed to close the journal: %v", err)
+		}
+	}
 }
 
-// Add добавляет запись в буфер
+// Add добавляет запись в буфер. Заполненный буфер сбрасывается фоновой
+// горутиной, поэтому сбор данных не ждет базу.
 func (stc *ScreenTimeCache) Add(st model.ScreenTime) {
 	stc.bufferMutex.Lock()
 	defer stc.bufferMutex.Unlock()
 
+	journaled := false
+	if stc.journal != nil {
+		if err := stc.journal.Append(st); err != nil {
+			log.Printf("Failed to write the journal: %v", err)
+		} else {
+			journaled = true
+		}
+	}
+
+	// пока база недоступна, запись хранится только в журнале
+	if stc.spilled {
+		if !journaled {
+			log.Printf("The database and the journal are unavailable, the sample is lost")
+		}
+		return
+	}
+
 	stc.buffer = append(stc.buffer, st)
+	if journaled {
+		stc.journaled++
+	}
 
 	// Если буфер заполнен, сбрасываем его
 	if len(stc.buffer) >= stc.maxBuffer {
-		stc.flushBuffer()
+		stc.signalFlush()
+	}
+}
+
+func (stc *ScreenTimeCache) signalFlush() {
+	select {
+	case stc.flushChan <- struct{}{}:
+	default:
 	}
 }
 
 // flushWorker периодически сбрасывает буфер в БД
 func (stc *ScreenTimeCache) flushWorker() {
+	stc.bufferMutex.Lock()
 	ticker := time.NewTicker(stc.flushPeriod)
+	stc.bufferMutex.Unlock()
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			stc.flushBuffer()
+		case <-stc.flushChan:
+			stc.flushBuffer()
+		case <-stc.resetChan:
+			stc.bufferMutex.Lock()
+			ticker.Reset(stc.flushPeriod)
+			stc.bufferMutex.Unlock()
 		case <-stc.stopChan:
 			return
 		}
 	}
 }
 
-// flushBuffer сбрасывает содержимое буфера в БД
+// flushBuffer сохраняет записи в БД и ждет результат. После неудачи
+// следующая попытка откладывается (см. failed).
 func (stc *ScreenTimeCache) flushBuffer() {
+	stc.flushMutex.Lock()
+	defer stc.flushMutex.Unlock()
+
+	if time.Now().Before(stc.retryAt) {
+		return
+	}
+
+	if stc.journal != nil {
+		if err := stc.journal.Sync(); err != nil {
+			log.Printf("Failed to sync the journal: %v", err)
+		}
+	}
+
 	stc.bufferMutex.Lock()
-	defer stc.bufferMutex.Unlock()
+	spilled := stc.spilled
+	stc.bufferMutex.Unlock()
 
-	if len(stc.buffer) == 0 {
+	if spilled {
+		stc.flushJournal()
+	} else {
+		stc.flushMemory()
+	}
+}
+
+// flushMemory сохраняет буфер. Пока база занята, новые записи копятся в
+// буфере, после maxFailures неудач подряд они остаются только в журнале.
+func (stc *ScreenTimeCache) flushMemory() {
+	stc.bufferMutex.Lock()
+	records, journaled := stc.buffer, stc.journaled
+	stc.buffer = make([]model.ScreenTime, 0, stc.maxBuffer)
+	stc.journaled = 0
+	stc.bufferMutex.Unlock()
+
+	if len(records) == 0 {
 		return
 	}
 
-	// Копируем буфер, чтобы минимизировать время блокировки
-	records := make([]model.ScreenTime, len(stc.buffer))
-	copy(records, stc.buffer)
+	if err := stc.db.BulkInsert(records); err != nil {
+		stc.failed(err)
+		stc.keep(records, journaled)
+		return
+	}
 
-	// Очищаем буфер
-	stc.buffer = stc.buffer[:0]
+	stc.failures = 0
+	if stc.journal != nil {
+		// записи попадают в буфер и журнал в одном порядке, поэтому
+		// сохраненные записи - первые journaled строк журнала. Записи,
+		// добавленные во время сохранения, остаются в нем.
+		if err := stc.journal.Drop(journaled); err != nil {
+			log.Printf("Failed to drop saved samples from the journal: %v", err)
+		}
+	}
+}
 
-	// Сохраняем в БД в отдельной горутине, чтобы не блокировать основной поток
-	go func() {
-		if err := stc.db.BulkInsert(records); err != nil {
-			log.Printf("Failed to bulk insert records: %v", err)
-			// При ошибке можно добавить записи обратно в буфер
-			stc.bufferMutex.Lock()
-			stc.buffer = append(stc.buffer, records...)
-			stc.bufferMutex.Unlock()
+// keep возвращает несохраненные записи (journaled из них в журнале) в
+// начало буфера или, если база недоступна слишком долго, оставляет их
+// только в журнале
+func (stc *ScreenTimeCache) keep(records []model.ScreenTime, journaled int) {
+	stc.bufferMutex.Lock()
+	defer stc.bufferMutex.Unlock()
+
+	stc.buffer = append(records, stc.buffer...)
+	stc.journaled += journaled
+
+	// с журналом буфер растет не дольше maxFailures неудач, а строки
+	// журнала нельзя отбросить вместе с самыми старыми записями
+	if stc.journal != nil {
+		if stc.failures >= maxFailures {
+			stc.spill()
 		}
-	}()
+		return
+	}
+
+	if dropped := len(stc.buffer) - maxMemoryRecords; dropped > 0 {
+		log.Printf("The buffer is full, dropping %d oldest samples", dropped)
+		stc.buffer = stc.buffer[dropped:]
+	}
+}
+
+// spill оставляет записи буфера только в журнале, записи, которые не
+// удалось в него дописать, теряются. Вызывается под bufferMutex.
+func (stc *ScreenTimeCache) spill() {
+	if lost := len(stc.buffer) - stc.journaled; lost > 0 {
+		log.Printf("Dropping %d samples that could not be written to the journal", lost)
+	}
+	log.Printf("Keeping %d samples in the journal until the database is available", stc.journal.Len())
+
+	stc.spilled = true
+	stc.buffer = make([]model.ScreenTime, 0, stc.maxBuffer)
+	stc.journaled = 0
+}
+
+// flushJournal сохраняет очередную часть журнала. Когда журнал пуст,
+// записи снова копятся в буфере.
+func (stc *ScreenTimeCache) flushJournal() {
+	records, read, err := stc.journal.Next(journalBatch)
+	if err == nil && len(records) > 0 {
+		err = stc.db.BulkInsert(records)
+	}
+	if err == nil {
+		// если удалить не удалось, при следующем чтении сохраненные записи будут пропущены
+		err = stc.journal.Drop(read)
+	}
+	if err != nil {
+		stc.failed(err)
+		return
+	}
+
+	stc.failures = 0
+
+	stc.bufferMutex.Lock()
+	defer stc.bufferMutex.Unlock()
+
+	if stc.journal.Len() > 0 {
+		stc.signalFlush()
+		return
+	}
+
+	log.Println("The journal is saved, buffering samples in memory again")
+	stc.spilled = false
+}
+
+// failed откладывает следующий сброс: пауза удваивается после каждой
+// неудачи подряд, начиная с периода сброса, но не больше maxRetryDelay
+func (stc *ScreenTimeCache) failed(err error) {
+	stc.failures++
+
+	stc.bufferMutex.Lock()
+	delay := stc.flushPeriod
+	stc.bufferMutex.Unlock()
+
+	for i := 1; i < stc.failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+
+	stc.retryAt = time.Now().Add(delay)
+	log.Printf("Failed to save samples (attempt %d), retrying in %v: %v", stc.failures, delay, err)
 }
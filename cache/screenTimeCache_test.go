@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// testJournal - журнал в памяти, Append которого можно сломать
+type testJournal struct {
+	records    []model.ScreenTime
+	failAppend bool
+}
+
+func (j *testJournal) Append(st model.ScreenTime) error {
+	if j.failAppend {
+		return errors.New("disk full")
+	}
+	j.records = append(j.records, st)
+	return nil
+}
+
+func (j *testJournal) Len() int     { return len(j.records) }
+func (j *testJournal) Sync() error  { return nil }
+func (j *testJournal) Close() error { return nil }
+
+func (j *testJournal) Next(limit int) ([]model.ScreenTime, int, error) {
+	n := min(limit, len(j.records))
+	return slices.Clone(j.records[:n]), n, nil
+}
+
+func (j *testJournal) Drop(n int) error {
+	j.records = j.records[min(n, len(j.records)):]
+	return nil
+}
+
+// testStore сохраняет записи в памяти, onInsert вызывается во время
+// сохранения, failures первых сохранений завершаются ошибкой
+type testStore struct {
+	records  []model.ScreenTime
+	failures int
+	onInsert func()
+}
+
+func (s *testStore) BulkInsert(records []model.ScreenTime) error {
+	if s.onInsert != nil {
+		s.onInsert()
+	}
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("database is locked")
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func appIDs(records []model.ScreenTime) []string {
+	var ids []string
+	for _, st := range records {
+		ids = append(ids, st.AppID)
+	}
+	return ids
+}
+
+func TestScreenTimeCacheDropsOnlyJournaledSamples(t *testing.T) {
+	type add struct {
+		appID      string
+		failAppend bool
+	}
+
+	tests := []struct {
+		name string
+		// adds - записи до сохранения, during - во время него
+		adds        []add
+		during      []add
+		failures    int
+		wantStored  []string
+		wantJournal []string
+	}{
+		{
+			name:       "all samples journaled",
+			adds:       []add{{appID: "a"}, {appID: "b"}},
+			wantStored: []string{"a", "b"},
+		},
+		{
+			name:        "newer samples added while saving stay in the journal",
+			adds:        []add{{appID: "a"}, {appID: "b", failAppend: true}},
+			during:      []add{{appID: "c"}},
+			wantStored:  []string{"a", "b"},
+			wantJournal: []string{"c"},
+		},
+		{
+			name:        "failed append in the middle",
+			adds:        []add{{appID: "a", failAppend: true}, {appID: "b"}, {appID: "c", failAppend: true}},
+			during:      []add{{appID: "d"}, {appID: "e"}},
+			wantStored:  []string{"a", "b", "c"},
+			wantJournal: []string{"d", "e"},
+		},
+		{
+			name:       "samples kept after a failed save",
+			adds:       []add{{appID: "a"}, {appID: "b", failAppend: true}},
+			during:     []add{{appID: "c"}},
+			failures:   1,
+			wantStored: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			journal := &testJournal{}
+			store := &testStore{failures: tt.failures}
+			stc := NewScreenTimeCache(store, time.Hour, 100)
+			stc.SetJournal(journal)
+
+			addAll := func(adds []add) {
+				for _, a := range adds {
+					journal.failAppend = a.failAppend
+					stc.Add(model.ScreenTime{AppID: a.appID, Sleep: 1000})
+				}
+				journal.failAppend = false
+			}
+
+			addAll(tt.adds)
+			store.onInsert = func() {
+				store.onInsert = nil
+				addAll(tt.during)
+			}
+
+			stc.flushMemory()
+			if tt.failures > 0 {
+				// повтор сохраняет и записи, добавленные во время первой попытки
+				stc.flushMemory()
+			}
+
+			if got := appIDs(store.records); !slices.Equal(got, tt.wantStored) {
+				t.Errorf("stored %v, want %v", got, tt.wantStored)
+			}
+			// в журнале остаются только несохраненные записи
+			if got := appIDs(journal.records); !slices.Equal(got, tt.wantJournal) {
+				t.Errorf("journal has %v, want %v", got, tt.wantJournal)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/subprogrammanager"
+)
+
+func runConfigCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: niri-screen-time config check [-config path] [-strict]")
+	}
+
+	switch args[0] {
+	case "check":
+		return runConfigCheck(args[1:])
+	}
+
+	return fmt.Errorf("unknown config command: %s", args[0])
+}
+
+// runConfigCheck prints every issue in config.yaml and subprograms.*,
+// errors (and warnings with -strict) make the command fail
+func runConfigCheck(args []string) error {
+	fs := newCommandFlagSet("config check", "[-config path] [-strict]")
+	configPath := fs.String("config", "", "Path to config.yaml")
+	strict := fs.Bool("strict", false, "Fail on warnings too")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	issues := config.Check(*configPath)
+	issues = append(issues, subprogrammanager.Check()...)
+
+	errorsCount := 0
+	for _, issue := range issues {
+		fmt.Println(issue)
+		if issue.Severity == config.SeverityError {
+			errorsCount++
+		}
+	}
+
+	warningsCount := len(issues) - errorsCount
+	fmt.Printf("%d error(s), %d warning(s)\n", errorsCount, warningsCount)
+
+	if errorsCount > 0 || (*strict && warningsCount > 0) {
+		return errors.New("config check failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/backupmanager"
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/retentionmanager"
+)
+
+const dbCommandUsage = "usage: niri-screen-time db <migrate|status|check|repair|prune|merge|backup|restore> [flags]"
+
+func runDBCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(dbCommandUsage)
+	}
+
+	switch args[0] {
+	case "migrate":
+		return runDBMigrate(args[1:])
+	case "status":
+		return runDBStatus(args[1:])
+	case "check":
+		return runDBCheck(args[1:])
+	case "repair":
+		return runDBRepair(args[1:])
+	case "prune":
+		return runDBPrune(args[1:])
+	case "merge":
+		return runDBMerge(args[1:])
+	case "backup":
+		return runDBBackup(args[1:])
+	case "restore":
+		return runDBRestore(args[1:])
+	}
+
+	return fmt.Errorf("unknown db command: %s", args[0])
+}
+
+// dbCommandFlags - flags shared by every db command
+type dbCommandFlags struct {
+	configPath *string
+	dbPath     *string
+}
+
+func addDBCommandFlags(fs *flag.FlagSet) dbCommandFlags {
+	fs.Func("tz", "Time zone for dates and day boundaries, defaults to the local zone", setTimeZone)
+
+	return dbCommandFlags{
+		configPath: fs.String("config", "", "Path to config.yaml"),
+		dbPath:     fs.String("db", "", "Path to the database file"),
+	}
+}
+
+// settings loads config.yaml with -db applied
+func (f dbCommandFlags) settings() (*config.Config, error) {
+	overrides := map[string]string{}
+	if *f.dbPath != "" {
+		overrides["storage.path"] = *f.dbPath
+	}
+
+	settings, err := config.Load(*f.configPath, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	return settings, nil
+}
+
+// runDBMigrate applies pending schema migrations, -dry-run only lists them
+func runDBMigrate(args []string) error {
+	fs := newCommandFlagSet("db migrate", "[-config path] [-db path] [-dry-run]")
+	flags := addDBCommandFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "List pending migrations without applying them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.NewDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
+
+	current, err := conn.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	pending, err := conn.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n", conn.Path())
+	fmt.Printf("Schema version: %d (latest %d)\n", current, db.LatestSchemaVersion())
+
+	if len(pending) == 0 {
+		fmt.Println("Schema is up to date")
+		return nil
+	}
+
+	for _, m := range pending {
+		note := ""
+		if m.Destructive {
+			note = " (destructive, a backup is taken first)"
+		}
+		fmt.Printf("  %3d  %s%s\n", m.Version, m.Name, note)
+	}
+
+	if *dryRun {
+		fmt.Printf("%d migration(s) pending, nothing applied (dry run)\n", len(pending))
+		return nil
+	}
+
+	applied, err := conn.Migrate()
+	fmt.Printf("%d migration(s) applied\n", len(applied))
+
+	return err
+}
+
+// runDBStatus prints the schema version and aggregation progress. The
+// database is opened read-only, so it is safe while the daemon runs.
+func runDBStatus(args []string) error {
+	fs := newCommandFlagSet("db status", "[-config path] [-db path]")
+	flags := addDBCommandFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.NewReadOnlyDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	current, err := conn.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
+
+	if err := conn.InitTables(); err != nil {
+		return err
+	}
+
+	state, err := db.NewAggregatorDB(conn).State()
+	if err != nil {
+		return err
+	}
+
+	lastRun := "never"
+	if state.UpdatedAt != nil {
+		lastRun = state.UpdatedAt.Local().Format(time.DateTime)
+	}
+
+	fmt.Printf("Database: %s\n", conn.Path())
+	fmt.Printf("Schema version: %d (latest %d)\n", current, db.LatestSchemaVersion())
+	fmt.Println("Aggregation:")
+	fmt.Printf("  pending samples:    %d\n", state.Pending)
+	fmt.Printf("  last aggregated id: %d\n", state.LastID)
+	fmt.Printf("  last batch:         %s\n", lastRun)
+	fmt.Printf("  batches:            %d\n", state.Batches)
+	fmt.Printf("  samples:            %d\n", state.Samples)
+	fmt.Printf("  sessions:           %d\n", state.Sessions)
+
+	return nil
+}
+
+// runDBPrune applies the retention settings now, -dry-run only reports
+// what would be removed
+func runDBPrune(args []string) error {
+	fs := newCommandFlagSet("db prune", "[-config path] [-db path] [-dry-run]")
+	flags := addDBCommandFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Report what would be removed without changing the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.NewDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
+
+	if err := conn.InitTables(); err != nil {
+		return err
+	}
+
+	rm := retentionmanager.NewRetentionManager(*db.NewRetentionDB(conn), settings.Retention)
+	result, err := rm.Prune(settings.Retention, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	rollupBefore, deleteBefore := retentionmanager.Cutoffs(settings.Retention, time.Now())
+
+	fmt.Printf("Database: %s\n", conn.Path())
+	if !rollupBefore.IsZero() {
+		fmt.Printf("Sessions before %s rolled up into daily summaries: %d (%d summary rows)\n",
+			rollupBefore.Format(time.DateOnly), result.RolledUp, result.SummaryRows)
+	}
+	if !deleteBefore.IsZero() {
+		fmt.Printf("Deleted before %s: %d sessions, %d samples, %d daily summaries\n",
+			deleteBefore.Format(time.DateOnly), result.Sessions, result.Samples, result.Summaries)
+	}
+	if rollupBefore.IsZero() && deleteBefore.IsZero() {
+		fmt.Println("Retention is disabled (retention.session_days and retention.summary_days are 0)")
+	}
+	if *dryRun {
+		fmt.Println("Nothing changed (dry run)")
+	}
+
+	return nil
+}
+
+// runDBMerge copies sessions and daily summaries from another machine's
+// database, skipping time that is already stored for the same host
+func runDBMerge(args []string) error {
+	fs := newCommandFlagSet("db merge", "[-config path] [-db path] [-dry-run] [-host name] other.db")
+	flags := addDBCommandFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Report what would be merged without changing the database")
+	host := fs.String("host", "", "Host name for the merged rows (required for databases without host names)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one database to merge")
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.NewDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
+
+	if err := conn.InitTables(); err != nil {
+		return err
+	}
+
+	other, err := openMergeSource(fs.Arg(0), settings, *host)
+	if err != nil {
+		return err
+	}
+	defer closeDB(other)
+
+	if other.Path() == conn.Path() {
+		return errors.New("cannot merge a database into itself")
+	}
+
+	result, err := db.NewMergeDB(conn).Merge(other, *host, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Merged %s into %s\n", other.Path(), conn.Path())
+	fmt.Printf("Sessions added: %d (%s), trimmed: %d, already stored: %d, skipped in rolled up days: %d\n",
+		result.Imported, time.Duration(result.Sleep)*time.Millisecond, result.Trimmed, result.Duplicates, result.RolledUp)
+	fmt.Printf("Daily summaries merged: %d, skipped (days with sessions): %d\n", result.Summaries, result.SkippedSummaries)
+	if *dryRun {
+		fmt.Println("Nothing changed (dry run)")
+	}
+
+	return nil
+}
+
+// openMergeSource opens the other database read-only. Its rows are
+// labelled with host when it predates host names, otherwise they would
+// be taken for this machine's.
+func openMergeSource(path string, settings *config.Config, host string) (*db.DBConnection, error) {
+	other, err := db.NewReadOnlyDBConnection(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := prepareMergeSource(other, settings, host); err != nil {
+		closeDB(other)
+		return nil, err
+	}
+
+	return other, nil
+}
+
+func prepareMergeSource(other *db.DBConnection, settings *config.Config, host string) error {
+	hasHost, err := other.HasHostColumn()
+	if err != nil {
+		return err
+	}
+	if !hasHost && host == "" {
+		return fmt.Errorf("%s has no host names, pass -host with the name of the machine it came from", other.Path())
+	}
+
+	if err := configureDB(other, settings); err != nil {
+		return err
+	}
+	other.SetHost(host)
+
+	return other.InitTables()
+}
+
+// runDBBackup writes a consistent copy of the database, safe while the
+// daemon runs. Without a path the copy goes to the backup directory and
+// old copies are rotated as by the daemon.
+func runDBBackup(args []string) error {
+	fs := newCommandFlagSet("db backup", "[-config path] [-db path] [backup.db]")
+	flags := addDBCommandFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("expected at most one backup path")
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.NewReadOnlyDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	path := fs.Arg(0)
+	var removed []string
+	if path != "" {
+		err = conn.Backup(path)
+	} else {
+		bm := backupmanager.NewBackupManager(conn, settings.Backup)
+		path, removed, err = bm.Backup(settings.Backup, time.Now())
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := db.ValidateBackup(path)
+	if err != nil {
+		return fmt.Errorf("backup was written but failed validation: %w", err)
+	}
+
+	fmt.Printf("Backup of %s saved to %s\n", conn.Path(), path)
+	printBackupInfo(info)
+	for _, old := range removed {
+		fmt.Printf("Removed old backup %s\n", old)
+	}
+
+	return nil
+}
+
+// runDBRestore replaces the database with a validated backup. The daemon
+// has to be stopped, the replaced database is kept next to it.
+func runDBRestore(args []string) error {
+	fs := newCommandFlagSet("db restore", "[-config path] [-db path] [-dry-run] backup.db")
+	flags := addDBCommandFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Only validate the backup")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one backup to restore")
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	info, err := db.ValidateBackup(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup %s is valid\n", fs.Arg(0))
+	printBackupInfo(info)
+	if *dryRun {
+		fmt.Println("Nothing changed (dry run)")
+		return nil
+	}
+
+	previous, err := db.Restore(settings.Storage.Path, fs.Arg(0))
+	if errors.Is(err, db.ErrLocked) {
+		return fmt.Errorf("%w, stop it before restoring", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Database restored")
+	if previous != "" {
+		fmt.Printf("The replaced database was kept as %s\n", previous)
+	}
+
+	return nil
+}
+
+func printBackupInfo(info db.BackupInfo) {
+	fmt.Printf("  schema version:  %d\n", info.SchemaVersion)
+	fmt.Printf("  sessions:        %d\n", info.Sessions)
+	fmt.Printf("  pending samples: %d\n", info.Samples)
+	fmt.Printf("  daily summaries: %d\n", info.Summaries)
+}
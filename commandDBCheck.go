@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/aggregatemanager"
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/db"
+)
+
+// staleIntervals - pending samples older than this many aggregation
+// intervals are reported, the daemon aggregates them on every interval
+const staleIntervals = 2
+
+// runDBCheck looks for damage and for rows left by crashes or by two
+// daemons writing at once. The database is opened read-only.
+func runDBCheck(args []string) error {
+	fs := newCommandFlagSet("db check", "[-config path] [-db path]")
+	flags := addDBCommandFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.NewReadOnlyDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
+
+	if err := conn.InitTables(); err != nil {
+		return err
+	}
+
+	window := staleIntervals * settings.Aggregation.Interval
+	result, err := db.NewCheckDB(conn).Check(time.Now(), window)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n", conn.Path())
+	printCheckResult(result, window)
+
+	switch {
+	case len(result.Integrity) > 0:
+		return errors.New("the database is damaged, restore a backup with db restore")
+	case result.Problems() == 0:
+		fmt.Println("No problems found")
+		return nil
+	case result.Repairable() > 0:
+		fmt.Printf("%d of them can be fixed with db repair\n", result.Repairable())
+	}
+
+	return fmt.Errorf("%d problem(s) found", result.Problems())
+}
+
+// runDBRepair fixes what db check finds where it can be done without
+// guessing. The daemon has to be stopped, the database is backed up first.
+func runDBRepair(args []string) error {
+	fs := newCommandFlagSet("db repair", "[-config path] [-db path]")
+	flags := addDBCommandFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	lock, err := db.LockDatabase(settings.Storage.Path)
+	if errors.Is(err, db.ErrLocked) {
+		return fmt.Errorf("%w, stop it before repairing", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			log.Println("runDBRepair", err)
+		}
+	}()
+
+	conn, err := db.NewDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
+
+	if err := conn.InitTables(); err != nil {
+		return err
+	}
+
+	return repairDB(conn, settings)
+}
+
+func repairDB(conn *db.DBConnection, settings *config.Config) error {
+	cdb := db.NewCheckDB(conn)
+	window := staleIntervals * settings.Aggregation.Interval
+
+	found, err := cdb.Check(time.Now(), window)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n", conn.Path())
+	if len(found.Integrity) > 0 {
+		printCheckResult(found, window)
+		return errors.New("the database is damaged and cannot be repaired, restore a backup with db restore")
+	}
+	if found.Repairable() == 0 {
+		fmt.Println("Nothing to repair")
+		return nil
+	}
+
+	backup := fmt.Sprintf("%s.pre-repair-%s.bak", conn.Path(), time.Now().Format("20060102-150405"))
+	if err := conn.Backup(backup); err != nil {
+		return fmt.Errorf("failed to back up the database before repairing: %w", err)
+	}
+	fmt.Printf("The database before repair was saved to %s\n", backup)
+
+	var result db.RepairResult
+	if err := cdb.RepairSamples(&result); err != nil {
+		return err
+	}
+
+	// leftover and stale samples are turned into sessions before the
+	// sessions are checked, so they are checked too
+	am := aggregatemanager.NewAggragetManager(db.NewAggregatorDB(conn), settings.Aggregation.Interval, settings.Aggregation.MaxGap)
+	aggregated, err := am.AggregatePending()
+	if err != nil {
+		return err
+	}
+
+	if err := cdb.RepairSessions(time.Now(), &result); err != nil {
+		return err
+	}
+
+	fmt.Println("Repaired:")
+	fmt.Printf("  invalid samples deleted:          %d\n", result.Samples)
+	fmt.Printf("  samples returned to aggregation:  %d\n", result.Rewound)
+	fmt.Printf("  samples aggregated:               %d into %d sessions\n", aggregated.Samples, aggregated.Sessions)
+	fmt.Printf("  negative rows deleted:            %d\n", result.Negative)
+	fmt.Printf("  inflated sessions clamped:        %d\n", result.Clamped)
+	fmt.Printf("  duplicate sessions deleted:       %d\n", result.Duplicates)
+	fmt.Printf("  overlapping sessions trimmed:     %d\n", result.Trimmed)
+	fmt.Printf("  covered sessions deleted:         %d\n", result.Covered)
+
+	if left := found.Problems() - found.Repairable(); left > 0 {
+		fmt.Printf("%d problem(s) need manual attention, see db check\n", left)
+	}
+
+	return nil
+}
+
+func printCheckResult(result db.CheckResult, window time.Duration) {
+	if len(result.Integrity) > 0 {
+		fmt.Println("Integrity check failed:")
+		for _, message := range result.Integrity {
+			fmt.Printf("  %s\n", message)
+		}
+		return
+	}
+
+	fmt.Println("Integrity: ok")
+	fmt.Println("Samples:")
+	fmt.Printf("  negative or longer than a day:    %d\n", result.Samples.Invalid)
+	fmt.Printf("  in the future:                    %d\n", result.Samples.Future)
+	fmt.Printf("  left behind by the aggregator:    %d\n", result.Samples.Leftover)
+	fmt.Printf("  pending for more than %-10v  %d\n", window, result.Samples.Stale)
+	fmt.Println("Sessions:")
+	fmt.Printf("  negative durations:               %d\n", result.Sessions.Negative)
+	fmt.Printf("  more time than their length:      %d\n", result.Sessions.Inflated)
+	fmt.Printf("  longer than a day:                %d\n", result.Sessions.TooLong)
+	fmt.Printf("  duplicates:                       %d\n", result.Sessions.Duplicates)
+	fmt.Printf("  overlapping:                      %d\n", result.Sessions.Overlaps)
+	fmt.Printf("  in the future:                    %d\n", result.Sessions.Future)
+	fmt.Println("Daily summaries:")
+	fmt.Printf("  negative durations:               %d\n", result.Summaries.Negative)
+	fmt.Printf("  longer than a day:                %d\n", result.Summaries.TooLong)
+	fmt.Printf("  in the future:                    %d\n", result.Summaries.Future)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/backupmanager"
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/privacy"
+)
+
+const dataCommandUsage = "usage: niri-screen-time data <purge|rename-app|redact-titles> [flags]"
+
+func runDataCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(dataCommandUsage)
+	}
+
+	switch args[0] {
+	case "purge":
+		return runDataPurge(args[1:])
+	case "rename-app":
+		return runDataRenameApp(args[1:])
+	case "redact-titles":
+		return runDataRedactTitles(args[1:])
+	}
+
+	return fmt.Errorf("unknown data command: %s", args[0])
+}
+
+// dataFilterFlags - row selection shared by purge and redact-titles. The
+// period flags are the ones of reports, but select every day by default.
+type dataFilterFlags struct {
+	app    *string
+	period *periodFlags
+	title  *string
+}
+
+func addDataFilterFlags(fs *flag.FlagSet, titleFlag string, titleUsage string) dataFilterFlags {
+	return dataFilterFlags{
+		app:    fs.String("app", "", "Only rows of this app_id"),
+		period: addPeriodFlags(fs, "unbounded by default"),
+		title:  fs.String(titleFlag, "", titleUsage),
+	}
+}
+
+func (f dataFilterFlags) filter(now time.Time, weekStart time.Weekday) (db.EditFilter, error) {
+	filter := db.EditFilter{AppID: *f.app}
+
+	if *f.title != "" {
+		re, err := regexp.Compile(*f.title)
+		if err != nil {
+			return filter, fmt.Errorf("invalid title pattern: %w", err)
+		}
+		filter.Title = re
+	}
+
+	var err error
+	filter.From, filter.To, err = f.period.bounds(now, weekStart)
+
+	return filter, err
+}
+
+// runDataPurge deletes matching samples, sessions and daily summaries
+func runDataPurge(args []string) error {
+	fs := newCommandFlagSet("data purge",
+		"[-config path] [-db path] [-dry-run] [-app id] [-title-match regex] [-period name | -since duration | -from date -to date]")
+	flags := addDBCommandFlags(fs)
+	filterFlags := addDataFilterFlags(fs, "title-match", "Only rows whose title matches this regular expression")
+	dryRun := fs.Bool("dry-run", false, "Report what would be deleted without changing the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	filter, err := filterFlags.filter(time.Now(), settings.Output.FirstWeekday())
+	if err != nil {
+		return err
+	}
+	if filter == (db.EditFilter{}) {
+		fs.Usage()
+		return errors.New("refusing to purge everything, select rows with -app, -title-match, -period, -since, -from or -to")
+	}
+
+	return runDataEdit(settings, *dryRun, "Deleted", true, func(edb *db.EditDB) (db.EditResult, error) {
+		return edb.Purge(filter, *dryRun)
+	})
+}
+
+// runDataRenameApp moves the whole history of one app_id to another
+func runDataRenameApp(args []string) error {
+	fs := newCommandFlagSet("data rename-app", "[-config path] [-db path] [-dry-run] old-app-id new-app-id")
+	flags := addDBCommandFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Report what would be renamed without changing the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || fs.Arg(0) == "" || fs.Arg(1) == "" {
+		fs.Usage()
+		return errors.New("expected the old and the new app_id")
+	}
+	if fs.Arg(0) == fs.Arg(1) {
+		return errors.New("the old and the new app_id are the same")
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	return runDataEdit(settings, *dryRun, "Renamed", false, func(edb *db.EditDB) (db.EditResult, error) {
+		return edb.RenameApp(fs.Arg(0), fs.Arg(1), *dryRun)
+	})
+}
+
+// runDataRedactTitles replaces matching titles with a placeholder
+func runDataRedactTitles(args []string) error {
+	fs := newCommandFlagSet("data redact-titles",
+		"[-config path] [-db path] [-dry-run] [-app id] [-period name | -since duration | -from date -to date] [-placeholder text] -match regex")
+	flags := addDBCommandFlags(fs)
+	filterFlags := addDataFilterFlags(fs, "match", "Redact titles matching this regular expression")
+	placeholder := fs.String("placeholder", privacy.DefaultPlaceholder, "Title stored instead of the redacted ones")
+	dryRun := fs.Bool("dry-run", false, "Report what would be redacted without changing the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *filterFlags.title == "" {
+		fs.Usage()
+		return errors.New("-match is required")
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	filter, err := filterFlags.filter(time.Now(), settings.Output.FirstWeekday())
+	if err != nil {
+		return err
+	}
+
+	return runDataEdit(settings, *dryRun, "Redacted", true, func(edb *db.EditDB) (db.EditResult, error) {
+		return edb.RedactTitles(filter, *placeholder, *dryRun)
+	})
+}
+
+// runDataEdit opens the database, applies edit and prints the affected
+// rows of every table. private edits remove data, so the copies they do
+// not reach are listed after them.
+func runDataEdit(
+	settings *config.Config,
+	dryRun bool,
+	verb string,
+	private bool,
+	edit func(edb *db.EditDB) (db.EditResult, error),
+) error {
+	conn, err := db.NewDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
+
+	if err := conn.InitTables(); err != nil {
+		return err
+	}
+
+	result, err := edit(db.NewEditDB(conn))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n", conn.Path())
+	fmt.Printf("%s:\n", verb)
+	printEditCount("samples", result.Samples)
+	printEditCount("sessions", result.Sessions)
+	printEditCount("daily summaries", result.Summaries)
+	if dryRun {
+		fmt.Println("Nothing changed (dry run)")
+		return nil
+	}
+
+	if private {
+		return printUneditedCopies(conn, settings)
+	}
+
+	return nil
+}
+
+// printUneditedCopies warns about the backups and the journal of unsaved
+// samples: they are not edited and may still hold the removed data
+func printUneditedCopies(conn *db.DBConnection, settings *config.Config) error {
+	bm := backupmanager.NewBackupManager(conn, settings.Backup)
+	backups, err := bm.List(settings.Backup)
+	if err != nil {
+		return err
+	}
+	if len(backups) > 0 {
+		dir, err := bm.Dir(settings.Backup)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Warning: the backups in %s (%d files) still contain the old data, delete them to remove it everywhere\n",
+			dir, len(backups))
+	}
+
+	journal := db.JournalPath(conn.Path())
+	if info, err := os.Stat(journal); err == nil && info.Size() > 0 {
+		fmt.Printf("Warning: %s holds samples the daemon has not saved yet, they are not edited\n", journal)
+	}
+
+	return nil
+}
+
+func printEditCount(name string, count db.EditCount) {
+	fmt.Printf("  %-16s %6d rows, %v\n", name+":", count.Rows, time.Duration(count.Sleep)*time.Millisecond)
+}
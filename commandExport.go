@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/exportmanager"
+)
+
+// runExportCommand streams sessions or summaries to stdout or a file.
+// The database is opened read-only, so it is safe while the daemon runs.
+func runExportCommand(args []string) (err error) {
+	fs := newCommandFlagSet("export",
+		"[-period name | -since duration | -from date -to date] [-format csv|json|ndjson] [-level sessions|daily|apps] [-output path]")
+	flags := addDBCommandFlags(fs)
+	period := addPeriodFlags(fs, "defaults to today")
+	format := fs.String("format", exportmanager.FormatCSV, "Output format: csv, json or ndjson")
+	level := fs.String("level", exportmanager.LevelSessions, "What to export: sessions, daily or apps")
+	output := fs.String("output", "", "Write to a file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := exportmanager.CheckOptions(*level, *format); err != nil {
+		return err
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	from, to, err := period.resolve(time.Now(), settings.Output.FirstWeekday())
+	if err != nil {
+		return err
+	}
+
+	conn, err := openReportDB(&Config{IsReadOnly: true, Settings: settings})
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	out := os.Stdout
+	if *output != "" {
+		var perm os.FileMode = 0600
+		out, err = os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if e := out.Close(); e != nil {
+				err = errors.Join(err, e)
+			}
+		}()
+	}
+
+	em := exportmanager.NewExportManager(db.NewExportDB(conn, settings.Aggregation.MaxGap))
+	if err := em.Export(*level, *format, out, &from, &to); err != nil {
+		return err
+	}
+
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Exported %s to %s\n", *level, *output)
+	}
+
+	return nil
+}
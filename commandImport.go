@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/daemon"
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/importmanager"
+)
+
+// runImportCommand imports ActivityWatch bucket exports and Timewarrior
+// data files as sessions
+func runImportCommand(args []string) error {
+	fs := newCommandFlagSet("import", "[-config path] [-db path] [-format activitywatch|timewarrior] [-dry-run] file...")
+	flags := addDBCommandFlags(fs)
+	format := fs.String("format", "", "activitywatch or timewarrior, detected by the file extension by default")
+	dryRun := fs.Bool("dry-run", false, "Report what would be imported without changing the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no files to import")
+	}
+
+	events := []importmanager.Event{}
+	for _, path := range fs.Args() {
+		source, parsed, err := parseImportFile(path, *format)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s: %d events (%s), %d skipped\n", path, len(parsed.Events), source, parsed.Skipped)
+		events = append(events, parsed.Events...)
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	conn, err := openReportDB(&Config{Settings: settings})
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	filter, err := daemon.NewIngestFilter(settings)
+	if err != nil {
+		return err
+	}
+
+	im := importmanager.NewImportManager(db.NewImportDB(conn), filter.Apply)
+	result, dropped, err := im.Import(events, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d sessions, %v in total\n", result.Imported, time.Duration(result.Sleep)*time.Millisecond)
+	fmt.Printf("  trimmed to avoid overlaps: %d\n", result.Trimmed)
+	fmt.Printf("  already covered:           %d\n", result.Duplicates)
+	fmt.Printf("  days already rolled up:    %d\n", result.RolledUp)
+	fmt.Printf("  dropped by privacy rules:  %d\n", dropped)
+	if *dryRun {
+		fmt.Println("Nothing changed (dry run)")
+	}
+
+	return nil
+}
+
+// parseImportFile reads path in format, an empty format is detected
+func parseImportFile(path string, format string) (string, importmanager.ParseResult, error) {
+	source := format
+	if source == "" {
+		var err error
+		if source, err = importmanager.DetectSource(path); err != nil {
+			return "", importmanager.ParseResult{}, err
+		}
+	}
+
+	file, err := os.Open(path) // #nosec G304 -- path is given by the user
+	if err != nil {
+		return source, importmanager.ParseResult{}, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println("parseImportFile", err)
+		}
+	}()
+
+	parsed, err := importmanager.Parse(source, file)
+	if err != nil {
+		return source, parsed, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return source, parsed, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/titlecrypt"
+)
+
+func runKeyCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: niri-screen-time key generate|encrypt-db [-config path]")
+	}
+
+	switch args[0] {
+	case "generate":
+		return runKeyGenerate(args[1:])
+	case "encrypt-db":
+		return runKeyEncryptDB(args[1:])
+	}
+
+	return fmt.Errorf("unknown key command: %s", args[0])
+}
+
+// runKeyGenerate writes a new key file, or prints a key for the
+// environment or the Secret Service with -print
+func runKeyGenerate(args []string) error {
+	fs := newCommandFlagSet("key generate", "[-config path] [-print]")
+	configPath := fs.String("config", "", "Path to config.yaml")
+	printKey := fs.Bool("print", false, "Print the key instead of writing the key file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *printKey {
+		key, err := titlecrypt.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+
+	settings, err := config.Load(*configPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	path, err := titlecrypt.KeyFilePath(settings.Encryption)
+	if err != nil {
+		return err
+	}
+
+	if err := titlecrypt.WriteKeyFile(path); err != nil {
+		return err
+	}
+
+	fmt.Println("Key written to", path)
+	fmt.Println("Keep a copy in a safe place: titles encrypted with a lost key cannot be recovered.")
+
+	return nil
+}
+
+// runKeyEncryptDB encrypts titles stored before encryption was enabled
+func runKeyEncryptDB(args []string) error {
+	fs := newCommandFlagSet("key encrypt-db", "[-config path] [-db path]")
+	flags := addDBCommandFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	settings, err := flags.settings()
+	if err != nil {
+		return err
+	}
+
+	if !settings.Encryption.Enabled {
+		return errors.New("encryption.enabled is false in config.yaml")
+	}
+
+	conn, err := db.NewDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
+
+	if err := conn.InitTables(); err != nil {
+		return err
+	}
+
+	count, err := conn.EncryptPlaintextTitles()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Encrypted %d title(s) in %s\n", count, conn.Path())
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/titlenormalizer"
+)
+
+// runNormalizeCommand previews how the configured pipeline changes a title
+func runNormalizeCommand(args []string) error {
+	fs := newCommandFlagSet("normalize", "[-config path] <title>...")
+	configPath := fs.String("config", "", "Path to config.yaml")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no title given")
+	}
+
+	settings, err := config.Load(*configPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	normalizer, err := titlenormalizer.NewNormalizer(settings.Normalization)
+	if err != nil {
+		return err
+	}
+
+	for _, title := range fs.Args() {
+		fmt.Printf("%-22s %q\n", "input", title)
+		for _, step := range normalizer.Steps(title) {
+			fmt.Printf("%-22s %q\n", step.Name, step.Title)
+		}
+		fmt.Printf("%-22s %q\n\n", "result", normalizer.Normalize(title))
+	}
+
+	return nil
+}
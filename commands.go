@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/probeldev/niri-screen-time/db"
+)
+
+// isCommand reports whether the first argument selects a subcommand
+// rather than a flag of the report mode
+func isCommand(args []string) bool {
+	return len(args) > 1 && !strings.HasPrefix(args[1], "-")
+}
+
+func runCommand(name string, args []string) error {
+	switch name {
+	case "config":
+		return runConfigCommand(args)
+	case "normalize":
+		return runNormalizeCommand(args)
+	case "key":
+		return runKeyCommand(args)
+	case "db":
+		return runDBCommand(args)
+	case "export":
+		return runExportCommand(args)
+	case "import":
+		return runImportCommand(args)
+	case "data":
+		return runDataCommand(args)
+	}
+
+	return fmt.Errorf("unknown command: %s", name)
+}
+
+// newCommandFlagSet creates a flag set for "niri-screen-time <command>"
+func newCommandFlagSet(command string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: niri-screen-time %s %s\n", command, usage)
+		fs.PrintDefaults()
+	}
+	fs.SetOutput(os.Stderr)
+
+	return fs
+}
+
+func closeDB(conn *db.DBConnection) {
+	if err := conn.Close(); err != nil {
+		log.Println("closeDB", err)
+	}
+}
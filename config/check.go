@@ -0,0 +1,259 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a problem found in a config file. Line and Column are
+// 1-based, zero means the position is unknown.
+type Issue struct {
+	File     string
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+}
+
+func (i Issue) String() string {
+	position := i.File
+	if i.Line > 0 {
+		position += ":" + strconv.Itoa(i.Line)
+	}
+	if i.Column > 0 {
+		position += ":" + strconv.Itoa(i.Column)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", position, i.Severity, i.Message)
+}
+
+// NewIssue creates an issue positioned at node (which may be nil)
+func NewIssue(file string, node *yaml.Node, severity Severity, format string, args ...any) Issue {
+	issue := Issue{
+		File:     file,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	}
+
+	if node != nil {
+		issue.Line = node.Line
+		issue.Column = node.Column
+	}
+
+	return issue
+}
+
+// ParseNode parses YAML or JSON content into a node tree. JSON syntax
+// errors are reported by encoding/json for precise positions, the tree
+// itself is built by the YAML parser because JSON is valid YAML.
+func ParseNode(file string, content []byte, isJSON bool) (*yaml.Node, []Issue) {
+	if isJSON {
+		var raw any
+		if err := json.Unmarshal(content, &raw); err != nil {
+			return nil, []Issue{jsonIssue(file, content, err)}
+		}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, YAMLIssues(file, &root, err)
+	}
+
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	return root.Content[0], nil
+}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+)(?:, column (\d+))?: (.*)`)
+
+// YAMLIssues converts a yaml.v3 error into positioned issues. root is
+// used to find the column when the error only carries a line.
+func YAMLIssues(file string, root *yaml.Node, err error) []Issue {
+	var messages []string
+
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+
+	issues := []Issue{}
+	for _, message := range messages {
+		issue := Issue{File: file, Severity: SeverityError, Message: message}
+
+		if m := yamlLinePattern.FindStringSubmatch(message); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+			issue.Column, _ = strconv.Atoi(m[2])
+			issue.Message = m[3]
+			if issue.Column == 0 {
+				issue.Column = lastColumnOnLine(root, issue.Line)
+			}
+		}
+
+		issues = append(issues, issue)
+	}
+
+	return issues
+}
+
+// lastColumnOnLine returns the column of the right-most node on line,
+// which for "key: value" is the offending value
+func lastColumnOnLine(node *yaml.Node, line int) int {
+	if node == nil {
+		return 0
+	}
+
+	column := 0
+	if node.Line == line {
+		column = node.Column
+	}
+
+	for _, child := range node.Content {
+		column = max(column, lastColumnOnLine(child, line))
+	}
+
+	return column
+}
+
+func jsonIssue(file string, content []byte, err error) Issue {
+	issue := Issue{File: file, Severity: SeverityError, Message: err.Error()}
+
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return issue
+	}
+
+	issue.Line = 1
+	issue.Column = 1
+	for _, c := range content[:min(offset, int64(len(content)))] {
+		if c == '\n' {
+			issue.Line++
+			issue.Column = 1
+			continue
+		}
+		issue.Column++
+	}
+
+	return issue
+}
+
+// UnknownKeys reports mapping keys that have no matching yaml tag in t.
+// Nested structs and slices of structs are checked recursively.
+func UnknownKeys(file string, node *yaml.Node, t reflect.Type) []Issue {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var issues []Issue
+
+	switch {
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for _, item := range node.Content {
+			issues = append(issues, UnknownKeys(file, item, t.Elem())...)
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := map[string]reflect.Type{}
+		for i := range t.NumField() {
+			fields[yamlKey(t.Field(i))] = t.Field(i).Type
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				issues = append(issues, NewIssue(file, key, SeverityWarning, "unknown key %q", key.Value))
+				continue
+			}
+
+			issues = append(issues, UnknownKeys(file, value, fieldType)...)
+		}
+	}
+
+	return issues
+}
+
+// findKey returns the value node of a dotted key, or nil
+func findKey(node *yaml.Node, key string) *yaml.Node {
+	for _, part := range strings.Split(key, ".") {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == part {
+				next = node.Content[i+1]
+			}
+		}
+		node = next
+	}
+
+	return node
+}
+
+// Check validates config.yaml at path (empty means DefaultPath)
+// together with the environment overrides. A missing file is fine.
+func Check(path string) []Issue {
+	if path == "" {
+		var err error
+		path, err = DefaultPath()
+		if err != nil {
+			return []Issue{{File: configFile, Severity: SeverityError, Message: err.Error()}}
+		}
+	}
+
+	cfg := Default()
+	issues := []Issue{}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return []Issue{{File: path, Severity: SeverityError, Message: err.Error()}}
+	}
+
+	root, parseIssues := ParseNode(path, content, false)
+	issues = append(issues, parseIssues...)
+
+	if root != nil {
+		issues = append(issues, UnknownKeys(path, root, reflect.TypeOf(cfg))...)
+
+		if err := root.Decode(&cfg); err != nil {
+			issues = append(issues, YAMLIssues(path, root, err)...)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		issues = append(issues, Issue{File: "environment", Severity: SeverityError, Message: err.Error()})
+	}
+
+	for _, p := range cfg.problems() {
+		issues = append(issues, NewIssue(path, findKey(root, p.key), SeverityError, "%s", p.String()))
+	}
+
+	return issues
+}
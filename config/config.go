@@ -0,0 +1,475 @@
+// Package config loads daemon and report settings from config.yaml.
+//
+// Values are resolved in the following order, later sources win:
+// built-in defaults, config.yaml, NIRI_SCREEN_TIME_* environment
+// variables and finally command line flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	appName    = "niri-screen-time"
+	configFile = "config.yaml"
+)
+
+type Config struct {
+	Sampling      Sampling      `yaml:"sampling"`
+	Storage       Storage       `yaml:"storage"`
+	Aggregation   Aggregation   `yaml:"aggregation"`
+	Output        Output        `yaml:"output"`
+	Backends      Backends      `yaml:"backends"`
+	Normalization Normalization `yaml:"normalization"`
+	Privacy       Privacy       `yaml:"privacy"`
+	Encryption    Encryption    `yaml:"encryption"`
+	Retention     Retention     `yaml:"retention"`
+	Backup        Backup        `yaml:"backup"`
+}
+
+// Sampling - how often the daemon asks the compositor for the active window
+type Sampling struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Storage - database location and buffering of samples between
+// the daemon and the database. An empty Path means the default
+// $XDG_DATA_HOME/niri-screen-time/db.db. Host names this machine in
+// databases merged from several machines, empty means the hostname.
+type Storage struct {
+	Path        string        `yaml:"path"`
+	FlushPeriod time.Duration `yaml:"flush_period"`
+	MaxBuffer   int           `yaml:"max_buffer"`
+	Host        string        `yaml:"host"`
+}
+
+// Aggregation - merging raw samples into sessions
+type Aggregation struct {
+	Interval time.Duration `yaml:"interval"`
+	MaxGap   time.Duration `yaml:"max_gap"`
+}
+
+// Output - formatting of reports. WeekStart is the first day of the
+// this-week and last-week report periods.
+type Output struct {
+	TruncateLength int    `yaml:"truncate_length"`
+	WeekStart      string `yaml:"week_start"`
+}
+
+// Backends - active window backend selection
+type Backends struct {
+	WindowManager string `yaml:"window_manager"`
+}
+
+// Normalization - cleanup of window titles, see package titlenormalizer.
+// AtReport applies it in details, AtIngest before titles are stored.
+type Normalization struct {
+	AtReport           bool          `yaml:"at_report"`
+	AtIngest           bool          `yaml:"at_ingest"`
+	StripCounters      bool          `yaml:"strip_counters"`
+	StripAppSuffixes   bool          `yaml:"strip_app_suffixes"`
+	AppSuffixes        []string      `yaml:"app_suffixes"`
+	CollapseWhitespace bool          `yaml:"collapse_whitespace"`
+	Replacements       []Replacement `yaml:"replacements"`
+}
+
+// Replacement - regexp replacement, Replace may refer to groups ("$1")
+type Replacement struct {
+	Pattern string `yaml:"pattern"`
+	Replace string `yaml:"replace"`
+}
+
+// Privacy - rules applied by the daemon before a sample is stored.
+// BuiltinRules adds rules for private browser windows and password
+// managers, they are checked after the user rules.
+type Privacy struct {
+	BuiltinRules bool          `yaml:"builtin_rules"`
+	Rules        []PrivacyRule `yaml:"rules"`
+}
+
+// PrivacyRule matches when every given matcher matches (app_id from
+// AppIDs or AppIDRegex, title by TitleRegex)
+type PrivacyRule struct {
+	AppIDs      []string `yaml:"app_ids"`
+	AppIDRegex  string   `yaml:"app_id_regex"`
+	TitleRegex  string   `yaml:"title_regex"`
+	Action      string   `yaml:"action"`
+	Placeholder string   `yaml:"placeholder"`
+}
+
+const (
+	// PrivacyActionDrop - the sample is not stored at all
+	PrivacyActionDrop = "drop"
+	// PrivacyActionRedact - the title is replaced with the placeholder
+	PrivacyActionRedact = "redact"
+	// PrivacyActionAppOnly - only the app_id is stored, the title is empty
+	PrivacyActionAppOnly = "app_only"
+)
+
+// Encryption - encryption of window titles at rest, see package
+// titlecrypt. KeyFile is used with KeySource "file", an empty value
+// means title.key in the config directory.
+type Encryption struct {
+	Enabled   bool   `yaml:"enabled"`
+	KeySource string `yaml:"key_source"`
+	KeyFile   string `yaml:"key_file"`
+}
+
+// Retention - how long data is kept. Sessions older than SessionDays
+// are rolled up into daily per-app/per-title summaries, everything older
+// than SummaryDays is deleted. Zero keeps data forever.
+type Retention struct {
+	Interval    time.Duration `yaml:"interval"`
+	SessionDays int           `yaml:"session_days"`
+	SummaryDays int           `yaml:"summary_days"`
+}
+
+// Backup - copies of the database written by the daemon every Interval,
+// zero disables them. An empty Dir means "backups" next to the database,
+// only the Keep newest copies are kept.
+type Backup struct {
+	Interval time.Duration `yaml:"interval"`
+	Dir      string        `yaml:"dir"`
+	Keep     int           `yaml:"keep"`
+}
+
+const (
+	KeySourceFile          = "file"
+	KeySourceEnv           = "env"
+	KeySourceSecretService = "secret-service"
+)
+
+const (
+	defaultSamplingInterval    = 200 * time.Millisecond
+	defaultFlushPeriod         = 5 * time.Second
+	defaultMaxBuffer           = 100
+	defaultAggregationInterval = 10 * time.Minute
+	defaultAggregationMaxGap   = time.Second
+	defaultTruncateLength      = 80
+	defaultWeekStart           = "monday"
+	defaultRetentionInterval   = 6 * time.Hour
+	defaultBackupInterval      = 24 * time.Hour
+	defaultBackupKeep          = 7
+
+	WindowManagerAuto      = "auto"
+	WindowManagerNiri      = "niri"
+	WindowManagerHyprland  = "hyprland"
+	WindowManagerAerospace = "aerospace"
+	WindowManagerMacOs     = "macos"
+)
+
+// Default returns the configuration used when nothing is overridden
+func Default() Config {
+	return Config{
+		Sampling: Sampling{
+			Interval: defaultSamplingInterval,
+		},
+		Storage: Storage{
+			FlushPeriod: defaultFlushPeriod,
+			MaxBuffer:   defaultMaxBuffer,
+		},
+		Aggregation: Aggregation{
+			Interval: defaultAggregationInterval,
+			MaxGap:   defaultAggregationMaxGap,
+		},
+		Output: Output{
+			TruncateLength: defaultTruncateLength,
+			WeekStart:      defaultWeekStart,
+		},
+		Backends: Backends{
+			WindowManager: WindowManagerAuto,
+		},
+		Normalization: Normalization{
+			StripCounters:      true,
+			StripAppSuffixes:   true,
+			CollapseWhitespace: true,
+		},
+		Privacy: Privacy{
+			BuiltinRules: true,
+		},
+		Encryption: Encryption{
+			KeySource: KeySourceFile,
+		},
+		Retention: Retention{
+			Interval: defaultRetentionInterval,
+		},
+		Backup: Backup{
+			Interval: defaultBackupInterval,
+			Keep:     defaultBackupKeep,
+		},
+	}
+}
+
+// Dir returns the configuration directory, honoring $XDG_CONFIG_HOME
+func Dir() (string, error) {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, appName), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	return filepath.Join(homeDir, ".config", appName), nil
+}
+
+// DefaultPath returns the path of config.yaml inside Dir
+func DefaultPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, configFile), nil
+}
+
+// Load reads the config file (a missing file is not an error), then
+// applies environment and flag overrides and validates the result.
+// An empty path means DefaultPath.
+func Load(path string, flagOverrides map[string]string) (*Config, error) {
+	if path == "" {
+		var err error
+		path, err = DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := Default()
+
+	if err := cfg.readFile(path); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	for key, value := range flagOverrides {
+		if err := cfg.Set(key, value); err != nil {
+			return nil, fmt.Errorf("flag -%s: %w", FlagName(key), err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (cfg *Config) readFile(path string) error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(file))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) applyEnv() error {
+	for _, s := range settings(cfg) {
+		value, ok := os.LookupEnv(EnvName(s.key))
+		if !ok {
+			continue
+		}
+
+		if err := s.set(value); err != nil {
+			return fmt.Errorf("%s: %w", EnvName(s.key), err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that every value is usable
+func (cfg *Config) Validate() error {
+	var errs []error
+	for _, p := range cfg.problems() {
+		errs = append(errs, errors.New(p.String()))
+	}
+
+	return errors.Join(errs...)
+}
+
+// problem is an invalid value of the setting with the given key
+type problem struct {
+	key     string
+	message string
+}
+
+func (p problem) String() string {
+	return p.key + " " + p.message
+}
+
+func (cfg *Config) problems() []problem {
+	var result []problem
+
+	// samples store their duration in whole milliseconds
+	if cfg.Sampling.Interval < time.Millisecond {
+		result = append(result, problem{"sampling.interval", "must be at least 1ms"})
+	}
+
+	if cfg.Storage.FlushPeriod <= 0 {
+		result = append(result, problem{"storage.flush_period", "must be positive"})
+	}
+
+	if cfg.Storage.MaxBuffer <= 0 {
+		result = append(result, problem{"storage.max_buffer", "must be positive"})
+	}
+
+	if cfg.Aggregation.Interval <= 0 {
+		result = append(result, problem{"aggregation.interval", "must be positive"})
+	}
+
+	if cfg.Aggregation.MaxGap < cfg.Sampling.Interval {
+		result = append(result, problem{"aggregation.max_gap", "must not be less than sampling.interval"})
+	}
+
+	if cfg.Output.TruncateLength < 0 {
+		result = append(result, problem{"output.truncate_length", "must not be negative"})
+	}
+
+	if _, ok := parseWeekday(cfg.Output.WeekStart); !ok {
+		result = append(result, problem{
+			"output.week_start",
+			fmt.Sprintf("has unknown value %q (monday, sunday, ...)", cfg.Output.WeekStart),
+		})
+	}
+
+	switch cfg.Backends.WindowManager {
+	case WindowManagerAuto,
+		WindowManagerNiri,
+		WindowManagerHyprland,
+		WindowManagerAerospace,
+		WindowManagerMacOs:
+	default:
+		result = append(result, problem{
+			"backends.window_manager",
+			fmt.Sprintf("has unknown value %q", cfg.Backends.WindowManager),
+		})
+	}
+
+	for _, r := range cfg.Normalization.Replacements {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			result = append(result, problem{"normalization.replacements", err.Error()})
+		}
+	}
+
+	switch cfg.Encryption.KeySource {
+	case KeySourceFile, KeySourceEnv, KeySourceSecretService:
+	default:
+		result = append(result, problem{
+			"encryption.key_source",
+			fmt.Sprintf("has unknown value %q (file, env, secret-service)", cfg.Encryption.KeySource),
+		})
+	}
+
+	result = append(result, cfg.Retention.problems()...)
+	result = append(result, cfg.Backup.problems()...)
+
+	return append(result, cfg.Privacy.problems()...)
+}
+
+// FirstWeekday returns WeekStart as a time.Weekday
+func (o Output) FirstWeekday() time.Weekday {
+	day, ok := parseWeekday(o.WeekStart)
+	if !ok {
+		return time.Monday
+	}
+	return day
+}
+
+// parseWeekday accepts an English day name in any case
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) {
+			return day, true
+		}
+	}
+	return time.Sunday, false
+}
+
+func (r *Retention) problems() []problem {
+	var result []problem
+
+	if r.Interval <= 0 {
+		result = append(result, problem{"retention.interval", "must be positive"})
+	}
+
+	if r.SessionDays < 0 {
+		result = append(result, problem{"retention.session_days", "must not be negative"})
+	}
+
+	if r.SummaryDays < 0 {
+		result = append(result, problem{"retention.summary_days", "must not be negative"})
+	}
+
+	if r.SummaryDays > 0 && r.SummaryDays < r.SessionDays {
+		result = append(result, problem{"retention.summary_days", "must not be less than retention.session_days"})
+	}
+
+	return result
+}
+
+func (b *Backup) problems() []problem {
+	var result []problem
+
+	if b.Interval < 0 {
+		result = append(result, problem{"backup.interval", "must not be negative"})
+	}
+
+	if b.Keep <= 0 {
+		result = append(result, problem{"backup.keep", "must be positive"})
+	}
+
+	return result
+}
+
+func (p *Privacy) problems() []problem {
+	var result []problem
+
+	for i, r := range p.Rules {
+		switch r.Action {
+		case PrivacyActionDrop, PrivacyActionRedact, PrivacyActionAppOnly:
+		default:
+			result = append(result, problem{
+				"privacy.rules",
+				fmt.Sprintf("rule %d: unknown action %q (drop, redact, app_only)", i+1, r.Action),
+			})
+		}
+
+		if len(r.AppIDs) == 0 && r.AppIDRegex == "" && r.TitleRegex == "" {
+			result = append(result, problem{"privacy.rules", fmt.Sprintf("rule %d: matches nothing", i+1)})
+		}
+
+		for _, expr := range []string{r.AppIDRegex, r.TitleRegex} {
+			if _, err := regexp.Compile(expr); err != nil {
+				result = append(result, problem{"privacy.rules", fmt.Sprintf("rule %d: %v", i+1, err)})
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "NIRI_SCREEN_TIME_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is a scalar value addressable by a "section.field" key
+type setting struct {
+	key   string
+	value reflect.Value
+}
+
+// settings lists every scalar field of cfg, keyed by yaml tags
+func settings(cfg *Config) []setting {
+	var result []setting
+	for _, s := range fields(cfg) {
+		if isScalar(s.value) {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// fields lists every field of every section of cfg
+func fields(cfg *Config) []setting {
+	var result []setting
+
+	root := reflect.ValueOf(cfg).Elem()
+	for i := range root.NumField() {
+		section := root.Field(i)
+		sectionKey := yamlKey(root.Type().Field(i))
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+
+		for j := range section.NumField() {
+			result = append(result, setting{
+				key:   sectionKey + "." + yamlKey(section.Type().Field(j)),
+				value: section.Field(j),
+			})
+		}
+	}
+
+	return result
+}
+
+func yamlKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func isScalar(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+func (s setting) set(raw string) error {
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(raw)
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	default:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(n)
+	}
+
+	return nil
+}
+
+// Set assigns a value by its "section.field" key
+func (cfg *Config) Set(key, value string) error {
+	for _, s := range settings(cfg) {
+		if s.key == key {
+			return s.set(value)
+		}
+	}
+
+	return fmt.Errorf("unknown setting %q", key)
+}
+
+// Keys returns every key accepted by Set
+func Keys() []string {
+	cfg := Default()
+
+	keys := []string{}
+	for _, s := range settings(&cfg) {
+		keys = append(keys, s.key)
+	}
+
+	return keys
+}
+
+// EnvName returns the environment variable overriding key,
+// e.g. sampling.interval -> NIRI_SCREEN_TIME_SAMPLING_INTERVAL
+func EnvName(key string) string {
+	name := strings.NewReplacer(".", "_", "-", "_").Replace(key)
+	return envPrefix + strings.ToUpper(name)
+}
+
+// FlagName returns the command line flag overriding key,
+// e.g. sampling.interval -> sampling-interval
+func FlagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+// RegisterFlags adds one flag per setting to fs. Only flags that were
+// actually passed end up in the returned map, so they override the
+// config file and the environment without masking them with defaults.
+func RegisterFlags(fs *flag.FlagSet) map[string]string {
+	overrides := map[string]string{}
+
+	cfg := Default()
+	for _, s := range settings(&cfg) {
+		key := s.key
+		usage := "override " + key + " from " + configFile
+		set := func(value string) error {
+			overrides[key] = value
+			return nil
+		}
+
+		// boolean flags work without a value: -normalization-at-report
+		if s.value.Kind() == reflect.Bool {
+			fs.Var(boolFlag(set), FlagName(key), usage)
+			continue
+		}
+		fs.Func(FlagName(key), usage, set)
+	}
+
+	return overrides
+}
+
+// boolFlag is a flag.Value accepted both as -name and -name=value
+type boolFlag func(string) error
+
+func (f boolFlag) Set(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return err
+	}
+	return f(value)
+}
+
+func (f boolFlag) String() string   { return "" }
+func (f boolFlag) IsBoolFlag() bool { return true }
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// keys that are only read on startup
+var restartKeys = []string{
+	"storage.path",
+	"storage.host",
+	"backends.window_manager",
+	"encryption.enabled",
+	"encryption.key_source",
+	"encryption.key_file",
+}
+
+// Store holds the current configuration of a long running process and
+// swaps it atomically on reload. An invalid file is rejected and the
+// last good configuration stays active.
+type Store struct {
+	path      string
+	overrides map[string]string
+	current   atomic.Pointer[Config]
+
+	mutex       sync.Mutex
+	subscribers []func(*Config)
+}
+
+func NewStore(path string, overrides map[string]string, cfg *Config) (*Store, error) {
+	if path == "" {
+		var err error
+		path, err = DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Store{
+		path:      path,
+		overrides: overrides,
+	}
+	s.current.Store(cfg)
+
+	return s, nil
+}
+
+// Get returns the active configuration, it must not be modified
+func (s *Store) Get() *Config {
+	return s.current.Load()
+}
+
+// Path returns the watched config file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// OnChange registers fn to be called with the new configuration after
+// every successful reload
+func (s *Store) OnChange(fn func(*Config)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// Reload re-reads the file with the same environment and flag overrides
+func (s *Store) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cfg, err := Load(s.path, s.overrides)
+	if err != nil {
+		return err
+	}
+
+	old := s.current.Swap(cfg)
+
+	for _, line := range Diff(old, cfg) {
+		log.Println("config:", line)
+	}
+
+	for _, fn := range s.subscribers {
+		fn(cfg)
+	}
+
+	return nil
+}
+
+// Watch reloads the configuration whenever the file changes
+func (s *Store) Watch() error {
+	fn := "config:Store:Watch"
+
+	return Watch(s.path, func() {
+		if err := s.Reload(); err != nil {
+			log.Println(fn, "keeping previous config:", err)
+		}
+	})
+}
+
+// Diff describes every setting that differs between a and b, lists
+// are reported as changed without their contents
+func Diff(a, b *Config) []string {
+	before := fields(a)
+	after := fields(b)
+
+	var lines []string
+	for i := range before {
+		old := before[i].value.Interface()
+		current := after[i].value.Interface()
+		if reflect.DeepEqual(old, current) {
+			continue
+		}
+
+		line := fmt.Sprintf("%s: %v -> %v", before[i].key, old, current)
+		if !isScalar(before[i].value) {
+			line = before[i].key + ": changed"
+		}
+		if slices.Contains(restartKeys, before[i].key) {
+			line += " (restart required)"
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	watchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_DELETE
+	// editors write files in several steps, changes are coalesced
+	watchDebounce = 300 * time.Millisecond
+	watchBufSize  = 64 * 1024
+)
+
+// Watch calls onChange whenever the file at path is written, replaced
+// or removed. Events are delivered by inotify on the parent directory,
+// so editors that save through a temporary file are handled too.
+func Watch(path string, onChange func()) error {
+	return WatchFiles([]string{path}, onChange)
+}
+
+// WatchFiles is Watch for several files, changes of any of them are
+// coalesced into one onChange call
+func WatchFiles(paths []string, onChange func()) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("inotify init: %w", err)
+	}
+
+	// names of the watched files by inotify watch descriptor of their directory
+	names := map[int32]map[string]bool{}
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+
+		var perm uint32 = 0755
+		if err := os.MkdirAll(dir, os.FileMode(perm)); err != nil {
+			_ = unix.Close(fd)
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+
+		wd, err := unix.InotifyAddWatch(fd, dir, watchMask)
+		if err != nil {
+			_ = unix.Close(fd)
+			return fmt.Errorf("inotify watch %s: %w", dir, err)
+		}
+
+		key := int32(wd) // #nosec G115 -- watch descriptors are small
+		if names[key] == nil {
+			names[key] = map[string]bool{}
+		}
+		names[key][filepath.Base(path)] = true
+	}
+
+	events := make(chan struct{}, 1)
+	go readEvents(fd, names, events)
+	go debounce(events, onChange)
+
+	return nil
+}
+
+func readEvents(fd int, names map[int32]map[string]bool, events chan<- struct{}) {
+	fn := "config:readEvents"
+	buf := make([]byte, watchBufSize)
+
+	for {
+		n, err := unix.Read(fd, buf)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			log.Println(fn, err)
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset])) // #nosec G103 -- inotify event layout
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			eventName := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			offset = nameEnd
+
+			if !names[event.Wd][eventName] {
+				continue
+			}
+
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func debounce(events <-chan struct{}, onChange func()) {
+	for range events {
+		time.Sleep(watchDebounce)
+		select {
+		case <-events:
+		default:
+		}
+		onChange()
+	}
+}
@@ -0,0 +1,53 @@
+//go:build !linux
+
+package config
+
+import (
+	"os"
+	"time"
+)
+
+const watchPollInterval = 2 * time.Second
+
+// Watch calls onChange whenever the file at path is written, replaced
+// or removed. Without inotify the modification time is polled.
+func Watch(path string, onChange func()) error {
+	return WatchFiles([]string{path}, onChange)
+}
+
+// WatchFiles is Watch for several files, changes found in one poll are
+// coalesced into one onChange call
+func WatchFiles(paths []string, onChange func()) error {
+	go func() {
+		last := make([]time.Time, len(paths))
+		for i, path := range paths {
+			last[i] = modTime(path)
+		}
+
+		for {
+			time.Sleep(watchPollInterval)
+
+			changed := false
+			for i, path := range paths {
+				if current := modTime(path); !current.Equal(last[i]) {
+					last[i] = current
+					changed = true
+				}
+			}
+
+			if changed {
+				onChange()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
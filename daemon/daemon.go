@@ -2,29 +2,52 @@
 package daemon
 
 import (
+	"context"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/probeldev/niri-screen-time/activewindowmanager"
 	"github.com/probeldev/niri-screen-time/cache"
+	"github.com/probeldev/niri-screen-time/config"
 	"github.com/probeldev/niri-screen-time/model"
 )
 
-const (
-	sleepMs  = 200
-	filename = "/home/sergey/screen-time.txt"
-)
-
-func Run(stc *cache.ScreenTimeCache) {
+// Run samples the active window until ctx is canceled. Settings are read
+// from store on every iteration, so a reloaded config applies to the next
+// sample. Run returns once the last sample has been added to stc.
+func Run(
+	ctx context.Context,
+	stc *cache.ScreenTimeCache,
+	wm activewindowmanager.ActiveWindowManagerInterface,
+	store *config.Store,
+) {
 	fn := "daemon:Run"
 
-	wm, err := activewindowmanager.GetActiveWindowManager()
-	if err != nil {
-		log.Panic(fn, err)
+	var filter atomic.Pointer[IngestFilter]
+	setFilter := func(cfg *config.Config) {
+		f, err := NewIngestFilter(cfg)
+		if err != nil {
+			log.Println(fn, "keeping previous ingest filter:", err)
+			return
+		}
+		filter.Store(f)
 	}
+	setFilter(store.Get())
+	store.OnChange(setFilter)
+
+	var samples sync.WaitGroup
+	defer samples.Wait()
 
 	for {
+		sampleInterval := store.Get().Sampling.Interval
+		sleepMs := int(sampleInterval.Milliseconds())
+
+		samples.Add(1)
 		go func() {
+			defer samples.Done()
+
 			appID, title, err := wm.GetActiveWindow()
 			if err != nil {
 				log.Panic(fn, err)
@@ -38,10 +61,16 @@ func Run(stc *cache.ScreenTimeCache) {
 					Sleep: sleepMs,
 				}
 
-				stc.Add(sc)
+				if sc, ok := filter.Load().Apply(sc); ok {
+					stc.Add(sc)
+				}
 			}
 		}()
 
-		time.Sleep(sleepMs * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sampleInterval):
+		}
 	}
 }
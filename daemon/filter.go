@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/model"
+	"github.com/probeldev/niri-screen-time/privacy"
+	"github.com/probeldev/niri-screen-time/titlenormalizer"
+)
+
+// IngestFilter - processing of samples before they are stored: privacy
+// rules and ingest-time normalization. The daemon rebuilds it on every
+// config reload, imports use it for foreign data.
+type IngestFilter struct {
+	privacy    *privacy.Filter
+	normalizer *titlenormalizer.Normalizer
+}
+
+func NewIngestFilter(cfg *config.Config) (*IngestFilter, error) {
+	privacyFilter, err := privacy.NewFilter(cfg.Privacy)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &IngestFilter{
+		privacy: privacyFilter,
+	}
+
+	if cfg.Normalization.AtIngest {
+		normalizer, err := titlenormalizer.NewNormalizer(cfg.Normalization)
+		if err != nil {
+			return nil, err
+		}
+		f.normalizer = normalizer
+	}
+
+	return f, nil
+}
+
+// Apply returns the sample to store, ok is false if it must be dropped
+// Privacy rules see the title as reported by the compositor, the
+// normalizer only runs on what they let through.
+func (f *IngestFilter) Apply(st model.ScreenTime) (model.ScreenTime, bool) {
+	st, ok := f.privacy.Apply(st)
+	if !ok {
+		return st, false
+	}
+
+	if f.normalizer != nil {
+		st.Title = f.normalizer.Normalize(st.Title)
+	}
+
+	return st, true
+}
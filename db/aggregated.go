@@ -2,11 +2,15 @@ package db
 
 import (
 	"log"
-	"time"
 
 	"github.com/probeldev/niri-screen-time/model"
 )
 
+// insertAggregatedQuery - date хранит конец сессии для совместимости
+const insertAggregatedQuery = "INSERT INTO aggregated_screen_time" +
+	"(date, started_at, ended_at, app_id, title, title_hash, sleep, source, host) " +
+	"VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
 type AggregatedScreenTimeDB struct {
 	conn *DBConnection
 }
@@ -16,21 +20,35 @@ func NewAggregatedScreenTimeDB(conn *DBConnection) *AggregatedScreenTimeDB {
 }
 
 func (astdb *AggregatedScreenTimeDB) Insert(ast model.AggregatedScreenTime) error {
-	_, err := astdb.conn.db.Exec(
-		"INSERT INTO aggregated_screen_time(date, app_id, title, sleep) VALUES(?, ?, ?, ?)",
-		ast.Date, ast.AppID, ast.Title, ast.Sleep,
-	)
-	return err
+	title, titleHash, err := astdb.conn.sealTitle(ast.Title)
+	if err != nil {
+		return err
+	}
+
+	return astdb.conn.write(func() error {
+		_, err := astdb.conn.db.Exec(
+			insertAggregatedQuery,
+			dbTime(ast.EndedAt), dbTime(ast.StartedAt), dbTime(ast.EndedAt),
+			ast.AppID, title, titleHash, ast.Sleep, ast.Source, astdb.conn.hostOr(ast.Host),
+		)
+		return err
+	})
 }
 
 func (astdb *AggregatedScreenTimeDB) BulkInsert(records []model.AggregatedScreenTime) error {
-	fn := "AggregatedScreenTimeDB:BulkInsert"
+	return astdb.conn.write(func() error {
+		return astdb.bulkInsert(records)
+	})
+}
+
+func (astdb *AggregatedScreenTimeDB) bulkInsert(records []model.AggregatedScreenTime) error {
+	fn := "AggregatedScreenTimeDB:bulkInsert"
 	tx, err := astdb.conn.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO aggregated_screen_time(date, app_id, title, sleep) VALUES(?, ?, ?, ?)")
+	stmt, err := tx.Prepare(insertAggregatedQuery)
 	if err != nil {
 		e := tx.Rollback()
 		if e != nil {
@@ -46,83 +64,26 @@ func (astdb *AggregatedScreenTimeDB) BulkInsert(records []model.AggregatedScreen
 	}()
 
 	for _, st := range records {
-		if _, err := stmt.Exec(st.Date, st.AppID, st.Title, st.Sleep); err != nil {
+		title, titleHash, err := astdb.conn.sealTitle(st.Title)
+		if err != nil {
 			e := tx.Rollback()
 			if e != nil {
 				log.Println(fn, err)
 			}
 			return err
 		}
-	}
-
-	return tx.Commit()
-}
-
-func (astdb *AggregatedScreenTimeDB) GetByDateRange(
-	from,
-	to *time.Time,
-) (
-	[]model.AggregatedScreenTime,
-	error,
-) {
-	fn := "AggregatedScreenTimeDB:GetByDateRange"
-
-	rows, err := astdb.conn.db.Query(
-		"SELECT date, app_id, title, sleep FROM aggregated_screen_time WHERE date BETWEEN ? AND ? ORDER BY date",
-		from, to,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			log.Println(fn, err)
-		}
-	}()
 
-	var results []model.AggregatedScreenTime
-	for rows.Next() {
-		var st model.AggregatedScreenTime
-		if err := rows.Scan(&st.Date, &st.AppID, &st.Title, &st.Sleep); err != nil {
-			return nil, err
-		}
-		results = append(results, st)
-	}
-
-	return results, nil
-}
-
-func (astdb *AggregatedScreenTimeDB) GetAppUsage(from, to time.Time) (map[string]int, error) {
-	fn := "AggregatedScreenTimeDb:GetAppUsage"
-	rows, err := astdb.conn.db.Query(
-		"SELECT app_id, SUM(sleep) FROM aggregated_screen_time WHERE date BETWEEN ? AND ? GROUP BY app_id",
-		from, to,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			log.Println(fn, err)
-		}
-	}()
-
-	result := make(map[string]int)
-	for rows.Next() {
-		var appID string
-		var total int
-		if err := rows.Scan(&appID, &total); err != nil {
-			return nil, err
+		if _, err := stmt.Exec(
+			dbTime(st.EndedAt), dbTime(st.StartedAt), dbTime(st.EndedAt),
+			st.AppID, title, titleHash, st.Sleep, st.Source, astdb.conn.hostOr(st.Host),
+		); err != nil {
+			e := tx.Rollback()
+			if e != nil {
+				log.Println(fn, err)
+			}
+			return err
 		}
-		result[appID] = total
 	}
 
-	return result, nil
-}
-
-func (astdb *AggregatedScreenTimeDB) DeleteOldRecords(before time.Time) error {
-	_, err := astdb.conn.db.Exec("DELETE FROM aggregated_screen_time WHERE date < ?", before)
-	return err
+	return tx.Commit()
 }
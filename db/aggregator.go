@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// AggregatorState - прогресс агрегации сырых записей в сессии.
+// LastID - id последней обработанной записи screen_time.
+type AggregatorState struct {
+	LastID    int
+	Batches   int
+	Samples   int
+	Sessions  int
+	UpdatedAt *time.Time
+	// Pending - записи screen_time, ожидающие агрегации
+	Pending int
+}
+
+// AggregatorDB - состояние агрегатора и атомарная запись пакета сессий
+type AggregatorDB struct {
+	conn *DBConnection
+}
+
+func NewAggregatorDB(conn *DBConnection) *AggregatorDB {
+	return &AggregatorDB{conn: conn}
+}
+
+// State возвращает сохраненный прогресс и число необработанных записей
+func (adb *AggregatorDB) State() (AggregatorState, error) {
+	var state AggregatorState
+	var updatedAt sql.NullTime
+
+	err := adb.conn.db.QueryRow(
+		"SELECT last_id, batches, samples, sessions, updated_at FROM aggregator_state WHERE id = 1",
+	).Scan(&state.LastID, &state.Batches, &state.Samples, &state.Sessions, &updatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return state, err
+	}
+	if updatedAt.Valid {
+		local := updatedAt.Time.Local()
+		state.UpdatedAt = &local
+	}
+
+	err = adb.conn.db.QueryRow(
+		"SELECT COUNT(*) FROM screen_time WHERE id > ?", state.LastID,
+	).Scan(&state.Pending)
+
+	return state, err
+}
+
+// NextBatch возвращает до limit записей screen_time с id больше afterID
+// в порядке записи
+func (adb *AggregatorDB) NextBatch(afterID, limit int) ([]model.ScreenTime, error) {
+	fn := "AggregatorDB:NextBatch"
+	rows, err := adb.conn.db.Query(
+		"SELECT id, date, app_id, title, sleep, host FROM screen_time WHERE id > ? ORDER BY id LIMIT ?",
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	var results []model.ScreenTime
+	for rows.Next() {
+		var st model.ScreenTime
+		if err := rows.Scan(&st.ID, &st.Date, &st.AppID, &st.Title, &st.Sleep, &st.Host); err != nil {
+			return nil, err
+		}
+		st.Date = st.Date.Local()
+		if st.Title, err = adb.conn.openTitle(st.Title); err != nil {
+			return nil, err
+		}
+		results = append(results, st)
+	}
+
+	return results, rows.Err()
+}
+
+// CommitBatch в одной транзакции сохраняет сессии, удаляет записи
+// screen_time с id в (afterID, lastID] и сдвигает отметку агрегатора.
+// При сбое не меняется ничего, поэтому данные не дублируются.
+func (adb *AggregatorDB) CommitBatch(
+	sessions []model.AggregatedScreenTime,
+	afterID int,
+	lastID int,
+) error {
+	return adb.conn.write(func() error {
+		return adb.commitBatch(sessions, afterID, lastID)
+	})
+}
+
+func (adb *AggregatorDB) commitBatch(
+	sessions []model.AggregatedScreenTime,
+	afterID int,
+	lastID int,
+) error {
+	fn := "AggregatorDB:commitBatch"
+
+	tx, err := adb.conn.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	stmt, err := tx.Prepare(insertAggregatedQuery)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := stmt.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for _, ast := range sessions {
+		title, titleHash, err := adb.conn.sealTitle(ast.Title)
+		if err != nil {
+			return err
+		}
+
+		if _, err := stmt.Exec(
+			dbTime(ast.EndedAt), dbTime(ast.StartedAt), dbTime(ast.EndedAt),
+			ast.AppID, title, titleHash, ast.Sleep, ast.Source, adb.conn.hostOr(ast.Host),
+		); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM screen_time WHERE id > ? AND id <= ?", afterID, lastID)
+	if err != nil {
+		return err
+	}
+
+	samples, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE aggregator_state
+		SET last_id = ?, batches = batches + 1, samples = samples + ?, sessions = sessions + ?, updated_at = ?
+		WHERE id = 1`,
+		lastID, samples, len(sessions), dbTime(time.Now()),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
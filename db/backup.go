@@ -0,0 +1,205 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// BackupInfo - сведения о проверенной резервной копии
+type BackupInfo struct {
+	SchemaVersion int
+	Sessions      int
+	Samples       int
+	Summaries     int
+}
+
+// Backup записывает согласованную копию базы в path (файл не должен
+// существовать). Копия снимается в одной транзакции чтения, поэтому демон
+// может продолжать запись. Файл появляется под именем path только целиком.
+func (dbc *DBConnection) Backup(path string) error {
+	fn := "DBConnection:Backup"
+
+	path, err := ExpandHome(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("file already exists: %s", path)
+	}
+
+	if dbc.readOnly {
+		// как и при переносе во временную копию, query_only снимается только для VACUUM INTO
+		if _, err := dbc.db.Exec("PRAGMA query_only = 0"); err != nil {
+			return err
+		}
+		defer func() {
+			if _, err := dbc.db.Exec("PRAGMA query_only = 1"); err != nil {
+				log.Println(fn, err)
+			}
+		}()
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.Remove(tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := dbc.VacuumInto(tmpPath); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// ValidateBackup проверяет, что path - целая база niri-screen-time,
+// которую может открыть эта версия
+func ValidateBackup(path string) (BackupInfo, error) {
+	var info BackupInfo
+
+	conn, err := NewReadOnlyDBConnection(path)
+	if err != nil {
+		return info, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Println("db:ValidateBackup", err)
+		}
+	}()
+
+	var check string
+	if err := conn.db.QueryRow("PRAGMA integrity_check").Scan(&check); err != nil {
+		return info, fmt.Errorf("%s is not a valid database: %w", conn.Path(), err)
+	}
+	if check != "ok" {
+		return info, fmt.Errorf("%s is damaged: %s", conn.Path(), check)
+	}
+
+	if info.SchemaVersion, err = conn.SchemaVersion(); err != nil {
+		return info, err
+	}
+	if info.SchemaVersion > LatestSchemaVersion() {
+		return info, fmt.Errorf("%s was written by a newer version (schema %d, supported %d)",
+			conn.Path(), info.SchemaVersion, LatestSchemaVersion())
+	}
+
+	for _, table := range []string{"screen_time", "aggregated_screen_time"} {
+		exists, err := hasTable(conn.db, table)
+		if err != nil {
+			return info, err
+		}
+		if !exists {
+			return info, fmt.Errorf("%s is not a niri-screen-time database (no %s table)", conn.Path(), table)
+		}
+	}
+
+	return info, countBackupRows(conn, &info)
+}
+
+func countBackupRows(conn *DBConnection, info *BackupInfo) error {
+	if err := conn.db.QueryRow("SELECT COUNT(*) FROM aggregated_screen_time").Scan(&info.Sessions); err != nil {
+		return err
+	}
+
+	if err := conn.db.QueryRow("SELECT COUNT(*) FROM screen_time").Scan(&info.Samples); err != nil {
+		return err
+	}
+
+	exists, err := hasTable(conn.db, "daily_summary")
+	if err != nil || !exists {
+		return err
+	}
+
+	return conn.db.QueryRow("SELECT COUNT(*) FROM daily_summary").Scan(&info.Summaries)
+}
+
+func hasTable(q querier, table string) (bool, error) {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+
+	return count > 0, err
+}
+
+// Restore заменяет базу dbPath проверенной копией backupPath. Текущая база
+// (вместе с журналом WAL) переименовывается и ее путь возвращается, пустой
+// - если базы не было. Пока работает демон, возвращается ErrLocked.
+func Restore(dbPath, backupPath string) (previous string, err error) {
+	fn := "db:Restore"
+
+	dbPath, err = resolveDBPath(dbPath)
+	if err != nil {
+		return "", err
+	}
+
+	lock, err := LockDatabase(dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	if _, err := ValidateBackup(backupPath); err != nil {
+		return "", err
+	}
+
+	// копия собирается рядом с базой, чтобы замена была одним rename
+	restorePath := dbPath + ".restore"
+	if err := copyBackup(backupPath, restorePath); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		previous = fmt.Sprintf("%s.pre-restore-%s.bak", dbPath, time.Now().Format("20060102-150405"))
+		if err := moveDatabase(dbPath, previous); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.Rename(restorePath, dbPath); err != nil {
+		return previous, fmt.Errorf("failed to restore %s, the previous database is %s: %w", dbPath, previous, err)
+	}
+
+	return previous, nil
+}
+
+func copyBackup(backupPath, path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	src, err := NewReadOnlyDBConnection(backupPath)
+	if err != nil {
+		return err
+	}
+
+	err = src.Backup(path)
+	if e := src.Close(); e != nil {
+		log.Println("db:copyBackup", e)
+	}
+
+	return err
+}
+
+// moveDatabase переименовывает файл базы вместе с журналом WAL, файл -shm
+// пересоздается SQLite
+func moveDatabase(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+
+	if err := os.Rename(from+"-wal", to+"-wal"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Remove(from + "-shm"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
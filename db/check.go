@@ -0,0 +1,455 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+const (
+	// futureTolerance - расхождение часов, при котором запись еще не
+	// считается записанной в будущем
+	futureTolerance = 5 * time.Minute
+	// checkTolerance - погрешность длительности и пересечения сессий
+	// (записи снимаются с небольшим дрожанием)
+	checkTolerance = time.Second
+)
+
+// SampleCheck - проблемы необработанных записей screen_time
+type SampleCheck struct {
+	// Invalid - отрицательная длительность или больше суток
+	Invalid int
+	Future  int
+	// Leftover - записи не старше отметки агрегатора, он их уже не увидит
+	Leftover int
+	// Stale - ожидающие агрегации дольше окна
+	Stale int
+}
+
+// SessionCheck - проблемы сессий aggregated_screen_time
+type SessionCheck struct {
+	// Negative - отрицательное время или конец раньше начала
+	Negative int
+	// Inflated - время больше длины сессии (например, от двух демонов)
+	Inflated int
+	// TooLong - сессия длиннее суток
+	TooLong    int
+	Duplicates int
+	// Overlaps - сессии, начатые до конца предыдущей сессии той же машины
+	Overlaps int
+	Future   int
+}
+
+// SummaryCheck - проблемы сумм daily_summary
+type SummaryCheck struct {
+	Negative int
+	TooLong  int
+	Future   int
+}
+
+// CheckResult - итог проверки базы
+type CheckResult struct {
+	// Integrity - сообщения PRAGMA integrity_check, пустой - база цела
+	Integrity []string
+	Samples   SampleCheck
+	Sessions  SessionCheck
+	Summaries SummaryCheck
+}
+
+// Repairable возвращает число проблем, которые исправляют RepairSamples,
+// проход агрегатора и RepairSessions
+func (r CheckResult) Repairable() int {
+	return r.Samples.Invalid + r.Samples.Leftover + r.Samples.Stale +
+		r.Sessions.Negative + r.Sessions.Inflated + r.Sessions.Duplicates + r.Sessions.Overlaps +
+		r.Summaries.Negative
+}
+
+// Problems возвращает число всех найденных проблем
+func (r CheckResult) Problems() int {
+	return len(r.Integrity) + r.Repairable() +
+		r.Samples.Future + r.Sessions.TooLong + r.Sessions.Future +
+		r.Summaries.TooLong + r.Summaries.Future
+}
+
+// RepairResult - что изменило исправление
+type RepairResult struct {
+	// Samples - удаленные записи с неверной длительностью
+	Samples int
+	// Rewound - записи, возвращенные агрегатору
+	Rewound int
+	// Negative - удаленные сессии и суммы с отрицательным временем
+	Negative int
+	// Clamped - сессии, время которых уменьшено до их длины
+	Clamped    int
+	Duplicates int
+	// Trimmed - сессии, начало которых сдвинуто на конец предыдущей
+	Trimmed int
+	// Covered - сессии, целиком закрытые предыдущими и удаленные
+	Covered int
+}
+
+// CheckDB - поиск и исправление записей, которые могли оставить сбои и
+// одновременная работа двух демонов
+type CheckDB struct {
+	conn *DBConnection
+}
+
+func NewCheckDB(conn *DBConnection) *CheckDB {
+	return &CheckDB{conn: conn}
+}
+
+// Check проверяет целостность файла и записи всех таблиц, не меняя базу.
+// Необработанные записи старше now - window считаются зависшими.
+func (cdb *CheckDB) Check(now time.Time, window time.Duration) (CheckResult, error) {
+	var result CheckResult
+	var err error
+
+	if result.Integrity, err = cdb.Integrity(); err != nil || len(result.Integrity) > 0 {
+		return result, err
+	}
+
+	if result.Samples, err = cdb.checkSamples(now, window); err != nil {
+		return result, err
+	}
+
+	sessions, err := scanCheckSessions(cdb.conn.db)
+	if err != nil {
+		return result, err
+	}
+	for _, fix := range planSessionFixes(sessions, now) {
+		fix.count(&result.Sessions)
+	}
+
+	result.Summaries, err = cdb.checkSummaries(now)
+
+	return result, err
+}
+
+// Integrity возвращает сообщения PRAGMA integrity_check, пустой - база цела
+func (cdb *CheckDB) Integrity() ([]string, error) {
+	fn := "CheckDB:Integrity"
+
+	rows, err := cdb.conn.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	var messages []string
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			return nil, err
+		}
+		if message != "ok" {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, rows.Err()
+}
+
+// Длительность записи не может быть больше суток (с переходом на летнее
+// время), аргумент - maxSessionLength в миллисекундах
+const invalidSampleCondition = "(sleep < 0 OR sleep > ?)"
+
+func (cdb *CheckDB) checkSamples(now time.Time, window time.Duration) (SampleCheck, error) {
+	var check SampleCheck
+
+	err := cdb.conn.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(`+invalidSampleCondition+`), 0),
+			COALESCE(SUM(t.date > ?), 0),
+			COALESCE(SUM(t.id <= s.last_id), 0),
+			COALESCE(SUM(t.id > s.last_id AND t.date < ?), 0)
+		FROM screen_time t, aggregator_state s WHERE s.id = 1`,
+		maxSessionLength.Milliseconds(), dbTime(now.Add(futureTolerance)), dbTime(now.Add(-window)),
+	).Scan(&check.Invalid, &check.Future, &check.Leftover, &check.Stale)
+
+	return check, err
+}
+
+func (cdb *CheckDB) checkSummaries(now time.Time) (SummaryCheck, error) {
+	var check SummaryCheck
+
+	err := cdb.conn.db.QueryRow(`
+		SELECT COALESCE(SUM(sleep < 0), 0), COALESCE(SUM(sleep > ?), 0), COALESCE(SUM(day > ?), 0)
+		FROM daily_summary`,
+		maxSessionLength.Milliseconds(), now.Format(dayFormat),
+	).Scan(&check.Negative, &check.TooLong, &check.Future)
+
+	return check, err
+}
+
+// RepairSamples в одной транзакции удаляет записи с неверной длительностью
+// и возвращает агрегатору записи не старше его отметки. Сами записи
+// объединяются в сессии следующим проходом агрегатора.
+func (cdb *CheckDB) RepairSamples(result *RepairResult) error {
+	return cdb.inTx("CheckDB:RepairSamples", func(tx *sql.Tx) error {
+		var err error
+		if result.Samples, err = execCount(tx,
+			"DELETE FROM screen_time WHERE "+invalidSampleCondition, maxSessionLength.Milliseconds(),
+		); err != nil {
+			return err
+		}
+
+		if err := tx.QueryRow(
+			"SELECT COUNT(*) FROM screen_time WHERE id <= (SELECT last_id FROM aggregator_state WHERE id = 1)",
+		).Scan(&result.Rewound); err != nil || result.Rewound == 0 {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE aggregator_state
+			SET last_id = (SELECT MIN(id) - 1 FROM screen_time WHERE id <= aggregator_state.last_id)
+			WHERE id = 1`)
+		return err
+	})
+}
+
+// RepairSessions в одной транзакции удаляет сессии с отрицательным
+// временем, повторы и сессии, закрытые предыдущими, обрезает пересечения
+// и уменьшает завышенное время до длины сессии. Суммы с отрицательным
+// временем удаляются. Сессии длиннее суток и записи из будущего не
+// меняются: исправить их без догадок нельзя.
+func (cdb *CheckDB) RepairSessions(now time.Time, result *RepairResult) error {
+	return cdb.inTx("CheckDB:RepairSessions", func(tx *sql.Tx) error {
+		sessions, err := scanCheckSessions(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, fix := range planSessionFixes(sessions, now) {
+			if err := fix.apply(tx, result); err != nil {
+				return err
+			}
+		}
+
+		n, err := execCount(tx, "DELETE FROM daily_summary WHERE sleep < 0")
+		result.Negative += n
+
+		return err
+	})
+}
+
+func (cdb *CheckDB) inTx(fn string, apply func(tx *sql.Tx) error) error {
+	tx, err := cdb.conn.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	if err := apply(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkSession - сессия в объеме, нужном проверке. Заголовок сравнивается
+// по title_key (см. daily_summary), поэтому расшифровка не нужна.
+type checkSession struct {
+	rowID     int64
+	startedAt time.Time
+	endedAt   time.Time
+	host      string
+	appID     string
+	titleKey  string
+	sleep     int
+}
+
+// Повторы идут подряд, потому что сессии отсортированы по всем
+// сравниваемым полям
+const checkSessionsQuery = `
+	SELECT rowid, started_at, ended_at, host, app_id,
+		CASE WHEN title_hash = '' THEN title ELSE title_hash END AS title_key, sleep
+	FROM aggregated_screen_time
+	ORDER BY host, started_at, ended_at, app_id, title_key, rowid`
+
+func scanCheckSessions(q querier) ([]checkSession, error) {
+	fn := "db:scanCheckSessions"
+
+	rows, err := q.Query(checkSessionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	var sessions []checkSession
+	for rows.Next() {
+		var s checkSession
+		if err := rows.Scan(&s.rowID, &s.startedAt, &s.endedAt, &s.host, &s.appID, &s.titleKey, &s.sleep); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// sessionFixKind - проблема сессии и способ ее исправить
+type sessionFixKind int
+
+const (
+	fixNegative sessionFixKind = iota
+	fixInflated
+	fixTooLong
+	fixDuplicate
+	fixOverlap
+	fixCovered
+	fixFuture
+)
+
+// sessionFix - найденная проблема сессии rowID. Для fixInflated и
+// fixOverlap startedAt и sleep - исправленные значения.
+type sessionFix struct {
+	kind      sessionFixKind
+	rowID     int64
+	startedAt time.Time
+	sleep     int
+}
+
+// planSessionFixes находит проблемы сессий, отсортированных запросом
+// checkSessionsQuery. Пересечения ищутся по каждой машине отдельно,
+// сессии длиннее суток в них не участвуют, иначе одна испорченная
+// сессия закрыла бы все следующие.
+func planSessionFixes(sessions []checkSession, now time.Time) []sessionFix {
+	var fixes []sessionFix
+	var prev *checkSession
+	var coveredUntil time.Time
+
+	for i := range sessions {
+		s := &sessions[i]
+		if prev != nil && prev.host != s.host {
+			prev, coveredUntil = nil, time.Time{}
+		}
+
+		if s.endedAt.After(now.Add(futureTolerance)) {
+			fixes = append(fixes, sessionFix{kind: fixFuture, rowID: s.rowID})
+		}
+
+		fix, ok := checkSessionLength(*s)
+		if ok {
+			fixes = append(fixes, fix)
+			if fix.kind != fixInflated {
+				continue
+			}
+			s.sleep = fix.sleep
+		}
+
+		if prev != nil && isDuplicateSession(*prev, *s) {
+			fixes = append(fixes, sessionFix{kind: fixDuplicate, rowID: s.rowID})
+			continue
+		}
+
+		if overlap, ok := checkSessionOverlap(*s, coveredUntil); ok {
+			fixes = append(fixes, overlap)
+			if overlap.kind == fixCovered {
+				continue
+			}
+		}
+
+		prev = s
+		if s.endedAt.After(coveredUntil) {
+			coveredUntil = s.endedAt
+		}
+	}
+
+	return fixes
+}
+
+// checkSessionLength проверяет длительность и время сессии
+func checkSessionLength(s checkSession) (sessionFix, bool) {
+	span := s.endedAt.Sub(s.startedAt)
+
+	switch {
+	case s.sleep < 0 || span < 0:
+		return sessionFix{kind: fixNegative, rowID: s.rowID}, true
+	case span > maxSessionLength:
+		return sessionFix{kind: fixTooLong, rowID: s.rowID}, true
+	case time.Duration(s.sleep)*time.Millisecond > span+checkTolerance:
+		return sessionFix{kind: fixInflated, rowID: s.rowID, startedAt: s.startedAt, sleep: int(span.Milliseconds())}, true
+	}
+
+	return sessionFix{}, false
+}
+
+func isDuplicateSession(a, b checkSession) bool {
+	return a.startedAt.Equal(b.startedAt) && a.endedAt.Equal(b.endedAt) &&
+		a.appID == b.appID && a.titleKey == b.titleKey
+}
+
+// checkSessionOverlap обрезает начало сессии s до coveredUntil - конца
+// предыдущих сессий той же машины
+func checkSessionOverlap(s checkSession, coveredUntil time.Time) (sessionFix, bool) {
+	if !s.startedAt.Before(coveredUntil.Add(-checkTolerance)) {
+		return sessionFix{}, false
+	}
+
+	if !s.endedAt.After(coveredUntil) {
+		return sessionFix{kind: fixCovered, rowID: s.rowID}, true
+	}
+
+	ast := model.AggregatedScreenTime{StartedAt: s.startedAt, EndedAt: s.endedAt, Sleep: s.sleep}
+	sleep := ast.ClippedSleep(coveredUntil, s.endedAt)
+
+	return sessionFix{kind: fixOverlap, rowID: s.rowID, startedAt: coveredUntil, sleep: sleep}, true
+}
+
+func (fix sessionFix) count(check *SessionCheck) {
+	switch fix.kind {
+	case fixNegative:
+		check.Negative++
+	case fixInflated:
+		check.Inflated++
+	case fixTooLong:
+		check.TooLong++
+	case fixDuplicate:
+		check.Duplicates++
+	case fixOverlap, fixCovered:
+		check.Overlaps++
+	case fixFuture:
+		check.Future++
+	}
+}
+
+func (fix sessionFix) apply(tx *sql.Tx, result *RepairResult) error {
+	var err error
+
+	switch fix.kind {
+	case fixNegative:
+		result.Negative++
+		_, err = tx.Exec("DELETE FROM aggregated_screen_time WHERE rowid = ?", fix.rowID)
+	case fixDuplicate:
+		result.Duplicates++
+		_, err = tx.Exec("DELETE FROM aggregated_screen_time WHERE rowid = ?", fix.rowID)
+	case fixCovered:
+		result.Covered++
+		_, err = tx.Exec("DELETE FROM aggregated_screen_time WHERE rowid = ?", fix.rowID)
+	case fixInflated:
+		result.Clamped++
+		_, err = tx.Exec("UPDATE aggregated_screen_time SET sleep = ? WHERE rowid = ?", fix.sleep, fix.rowID)
+	case fixOverlap:
+		result.Trimmed++
+		_, err = tx.Exec("UPDATE aggregated_screen_time SET started_at = ?, sleep = ? WHERE rowid = ?",
+			dbTime(fix.startedAt), fix.sleep, fix.rowID)
+	case fixTooLong, fixFuture:
+	}
+
+	return err
+}
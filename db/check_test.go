@@ -0,0 +1,187 @@
+package db
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var checkStart = time.Date(2025, time.March, 10, 10, 0, 0, 0, time.UTC)
+
+// session - сессия rowID окна app машины host с start по end от checkStart
+func session(rowID int64, host string, start, end time.Duration, app string, sleep time.Duration) checkSession {
+	return checkSession{
+		rowID:     rowID,
+		startedAt: checkStart.Add(start),
+		endedAt:   checkStart.Add(end),
+		host:      host,
+		appID:     app,
+		titleKey:  "title",
+		sleep:     int(sleep.Milliseconds()),
+	}
+}
+
+func TestPlanSessionFixes(t *testing.T) {
+	now := checkStart.Add(72 * time.Hour)
+
+	tests := []struct {
+		name     string
+		sessions []checkSession
+		want     []sessionFix
+	}{
+		{
+			name: "consecutive sessions",
+			sessions: []checkSession{
+				session(1, "desktop", 0, time.Hour, "kitty", time.Hour),
+				session(2, "desktop", time.Hour, 2*time.Hour, "firefox", time.Hour),
+			},
+		},
+		{
+			name: "duplicate",
+			sessions: []checkSession{
+				session(1, "desktop", 0, time.Hour, "kitty", time.Hour),
+				session(2, "desktop", 0, time.Hour, "kitty", time.Hour),
+			},
+			want: []sessionFix{{kind: fixDuplicate, rowID: 2}},
+		},
+		{
+			name: "same span of another app is covered, not a duplicate",
+			sessions: []checkSession{
+				session(1, "desktop", 0, time.Hour, "kitty", time.Hour),
+				session(2, "desktop", 0, time.Hour, "firefox", time.Hour),
+			},
+			want: []sessionFix{{kind: fixCovered, rowID: 2}},
+		},
+		{
+			name: "covered session",
+			sessions: []checkSession{
+				session(1, "desktop", 0, time.Hour, "kitty", time.Hour),
+				session(2, "desktop", 10*time.Minute, 20*time.Minute, "firefox", 10*time.Minute),
+				session(3, "desktop", 30*time.Minute, 2*time.Hour, "mpv", 90*time.Minute),
+			},
+			want: []sessionFix{
+				{kind: fixCovered, rowID: 2},
+				{kind: fixOverlap, rowID: 3, startedAt: checkStart.Add(time.Hour), sleep: int(time.Hour.Milliseconds())},
+			},
+		},
+		{
+			name: "overlap is trimmed to the end of the previous session",
+			sessions: []checkSession{
+				session(1, "desktop", 0, time.Hour, "kitty", time.Hour),
+				session(2, "desktop", 30*time.Minute, 90*time.Minute, "firefox", time.Hour),
+			},
+			want: []sessionFix{
+				{kind: fixOverlap, rowID: 2, startedAt: checkStart.Add(time.Hour), sleep: int((30 * time.Minute).Milliseconds())},
+			},
+		},
+		{
+			name: "overlap within the tolerance",
+			sessions: []checkSession{
+				session(1, "desktop", 0, time.Hour, "kitty", time.Hour),
+				session(2, "desktop", time.Hour-500*time.Millisecond, 2*time.Hour, "firefox", time.Hour),
+			},
+		},
+		{
+			name: "inflated sleep",
+			sessions: []checkSession{
+				session(1, "desktop", 0, 10*time.Minute, "kitty", 20*time.Minute),
+			},
+			want: []sessionFix{
+				{kind: fixInflated, rowID: 1, startedAt: checkStart, sleep: int((10 * time.Minute).Milliseconds())},
+			},
+		},
+		{
+			name: "inflated sleep within the tolerance",
+			sessions: []checkSession{
+				session(1, "desktop", 0, 10*time.Minute, "kitty", 10*time.Minute+500*time.Millisecond),
+			},
+		},
+		{
+			name: "overlap of an inflated session is trimmed from the clamped sleep",
+			sessions: []checkSession{
+				session(1, "desktop", 0, time.Hour, "kitty", time.Hour),
+				session(2, "desktop", 30*time.Minute, 90*time.Minute, "firefox", 2*time.Hour),
+			},
+			want: []sessionFix{
+				{kind: fixInflated, rowID: 2, startedAt: checkStart.Add(30 * time.Minute), sleep: int(time.Hour.Milliseconds())},
+				{kind: fixOverlap, rowID: 2, startedAt: checkStart.Add(time.Hour), sleep: int((30 * time.Minute).Milliseconds())},
+			},
+		},
+		{
+			name: "sessions of different hosts may overlap",
+			sessions: []checkSession{
+				session(1, "desktop", 0, time.Hour, "kitty", time.Hour),
+				session(2, "laptop", 30*time.Minute, 90*time.Minute, "kitty", time.Hour),
+			},
+		},
+		{
+			name: "coverage is reset for the next host",
+			sessions: []checkSession{
+				session(1, "desktop", 0, 3*time.Hour, "kitty", 3*time.Hour),
+				session(2, "laptop", time.Hour, 2*time.Hour, "kitty", time.Hour),
+				session(3, "laptop", 90*time.Minute, 2*time.Hour, "firefox", 30*time.Minute),
+			},
+			want: []sessionFix{{kind: fixCovered, rowID: 3}},
+		},
+		{
+			name: "negative and too long sessions do not cover the next ones",
+			sessions: []checkSession{
+				session(1, "desktop", 0, 30*time.Hour, "kitty", time.Hour),
+				session(2, "desktop", time.Hour, 0, "kitty", time.Hour),
+				session(3, "desktop", 2*time.Hour, 3*time.Hour, "firefox", time.Hour),
+			},
+			want: []sessionFix{
+				{kind: fixTooLong, rowID: 1},
+				{kind: fixNegative, rowID: 2},
+			},
+		},
+		{
+			name: "future session",
+			sessions: []checkSession{
+				session(1, "desktop", 72*time.Hour, 73*time.Hour, "kitty", time.Hour),
+			},
+			want: []sessionFix{{kind: fixFuture, rowID: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planSessionFixes(tt.sessions, now)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("planSessionFixes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSessionOverlap(t *testing.T) {
+	s := session(1, "desktop", time.Hour, 2*time.Hour, "kitty", time.Hour)
+
+	tests := []struct {
+		name         string
+		coveredUntil time.Duration
+		want         sessionFix
+		ok           bool
+	}{
+		{name: "before the session", coveredUntil: 30 * time.Minute},
+		{name: "at the start", coveredUntil: time.Hour},
+		{name: "within the tolerance", coveredUntil: time.Hour + checkTolerance},
+		{
+			name:         "into the session",
+			coveredUntil: 75 * time.Minute,
+			want:         sessionFix{kind: fixOverlap, rowID: 1, startedAt: checkStart.Add(75 * time.Minute), sleep: int((45 * time.Minute).Milliseconds())},
+			ok:           true,
+		},
+		{name: "at the end", coveredUntil: 2 * time.Hour, want: sessionFix{kind: fixCovered, rowID: 1}, ok: true},
+		{name: "past the end", coveredUntil: 3 * time.Hour, want: sessionFix{kind: fixCovered, rowID: 1}, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := checkSessionOverlap(s, checkStart.Add(tt.coveredUntil))
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("checkSessionOverlap() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -3,25 +3,57 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"os"
 	"sync"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// busyTimeout - сколько ждать блокировку, которую держит другой процесс
+const busyTimeout = 5 * time.Second
+
 type DBConnection struct {
-	db    *sql.DB
-	sem   chan struct{} // Семафор для ограничения параллелизма
-	mutex sync.Mutex    // Мьютекс для операций DDL (CREATE TABLE и т.д.)
+	db       *sql.DB
+	mutex    sync.Mutex // Мьютекс для операций DDL (CREATE TABLE и т.д.)
+	writer   *writer    // горутина записи демона, nil - запись напрямую
+	path     string
+	readOnly bool
+	cipher   TitleCipher
+	tmpDir   string // временная копия базы только для чтения
+	host     string
 }
 
-func NewDBConnection() (*DBConnection, error) {
-	dbPath, err := getDBPath()
+// NewDBConnection открывает базу на чтение и запись, пустой путь - база по умолчанию
+func NewDBConnection(dbPath string) (*DBConnection, error) {
+	dbPath, err := resolveDBPath(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureDir(dbPath); err != nil {
+		return nil, err
+	}
+
+	// Транзакции сразу берут блокировку записи (_txlock=immediate): иначе
+	// транзакция, начатая чтением, получала бы SQLITE_BUSY без ожидания,
+	// если базу одновременно меняет другой процесс.
+	connStr := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_txlock=immediate", dbPath, busyTimeout.Milliseconds())
+
+	db, err := openSQLite(connStr)
 	if err != nil {
 		return nil, err
 	}
 
-	connStr := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_mutex=full", dbPath)
+	return &DBConnection{
+		db:   db,
+		path: dbPath,
+	}, nil
+}
 
+// openSQLite открывает базу на чтение и запись в режиме WAL
+func openSQLite(connStr string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -35,61 +67,142 @@ func NewDBConnection() (*DBConnection, error) {
 		return nil, fmt.Errorf("failed to set pragmas: %w", err)
 	}
 
+	return db, nil
+}
+
+// NewReadOnlyDBConnection открывает существующую базу только для чтения,
+// например архивную копию или базу с другой машины. Файл не создается
+// и не изменяется.
+func NewReadOnlyDBConnection(dbPath string) (*DBConnection, error) {
+	dbPath, err := resolveDBPath(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	connStr := fmt.Sprintf("file:%s?mode=ro&_pragma=query_only(1)&_pragma=busy_timeout(%d)", dbPath, busyTimeout.Milliseconds())
+
+	// Даже в режиме ro SQLite создает рядом с базой в режиме WAL файлы -wal
+	// и -shm. Если базу никто не меняет, она открывается как неизменяемая
+	// (immutable=1), и рядом ничего не появляется: так можно читать базу на
+	// носителе только для чтения или в чужом каталоге. Непустой -wal
+	// содержит данные, которые immutable не прочитал бы.
+	if !isLocked(dbPath) && !hasWAL(dbPath) {
+		connStr = fmt.Sprintf("file:%s?mode=ro&immutable=1&_pragma=query_only(1)", dbPath)
+	}
+
+	db, err := sql.Open("sqlite", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
 	return &DBConnection{
-		db:  db,
-		sem: make(chan struct{}, 1), // Буфер = 1 (только одна операция записи за раз)
+		db:       db,
+		path:     dbPath,
+		readOnly: true,
 	}, nil
 }
 
+// hasWAL сообщает, есть ли у базы непустой файл -wal
+func hasWAL(dbPath string) bool {
+	info, err := os.Stat(dbPath + "-wal")
+	return err == nil && info.Size() > 0
+}
+
+// Path возвращает путь к файлу базы данных
+func (dbc *DBConnection) Path() string {
+	return dbc.path
+}
+
+// SetHost задает имя этой машины (storage.host), пустое - имя хоста системы
+func (dbc *DBConnection) SetHost(host string) {
+	dbc.host = host
+}
+
+// Host возвращает имя этой машины, им помечаются новые записи без host
+func (dbc *DBConnection) Host() string {
+	return hostName(dbc.host)
+}
+
+// hostName возвращает configured или, если оно пустое, системное имя машины
+func hostName(configured string) string {
+	if configured != "" {
+		return configured
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "localhost"
+	}
+
+	return hostname
+}
+
+// IsReadOnly сообщает, открыта ли база только для чтения
+func (dbc *DBConnection) IsReadOnly() bool {
+	return dbc.readOnly
+}
+
 // Close закрывает подключение к БД
 func (dbc *DBConnection) Close() error {
-	close(dbc.sem)
-	return dbc.db.Close()
-}
+	if dbc.writer != nil {
+		dbc.writer.stop()
+	}
+	err := dbc.db.Close()
+
+	if dbc.tmpDir != "" {
+		if e := os.RemoveAll(dbc.tmpDir); e != nil {
+			log.Println("DBConnection:Close", e)
+		}
+	}
 
-// InitTables создает таблицы (использует мьютекс для безопасности)
-func (dbc *DBConnection) InitTables() error {
-	dbc.mutex.Lock()
-	defer dbc.mutex.Unlock()
-
-	_, err := dbc.db.Exec(`
-	CREATE TABLE IF NOT EXISTS screen_time (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		date TIMESTAMP NOT NULL,
-		app_id TEXT NOT NULL,
-		title TEXT NOT NULL,
-		sleep INTEGER NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS aggregated_screen_time (
-		date TIMESTAMP NOT NULL,
-		app_id TEXT NOT NULL,
-		title TEXT NOT NULL,
-		sleep INTEGER NOT NULL
-	);
-	`)
 	return err
 }
 
-// Exec выполняет запрос с ограничением параллелизма
-func (dbc *DBConnection) Exec(query string, args ...any) (sql.Result, error) {
-	dbc.sem <- struct{}{}        // Захватываем слот
-	defer func() { <-dbc.sem }() // Освобождаем
+// InitTables создает таблицы и применяет недостающие миграции схемы.
+// База, открытая только для чтения, не изменяется: если ее схема устарела,
+// отчеты строятся по временной обновленной копии.
+func (dbc *DBConnection) InitTables() error {
+	if !dbc.readOnly {
+		_, err := dbc.Migrate()
+		return err
+	}
+
+	pending, err := dbc.PendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
 
-	return dbc.db.Exec(query, args...)
+	return dbc.migrateReadOnlyCopy()
 }
 
-// Query выполняет запрос на чтение (без ограничений)
-func (dbc *DBConnection) Query(query string, args ...any) (*sql.Rows, error) {
-	return dbc.db.Query(query, args...)
+// Vacuum сжимает базу и затирает освобожденные страницы
+func (dbc *DBConnection) Vacuum() error {
+	return dbc.write(func() error {
+		_, err := dbc.db.Exec("VACUUM")
+		return err
+	})
 }
 
-// GetDB возвращает *sql.DB (для обратной совместимости)
-func (dbc *DBConnection) GetDB() *sql.DB {
-	return dbc.db
+// hostOr возвращает host или имя этой машины
+func (dbc *DBConnection) hostOr(host string) string {
+	return hostOr(dbc.host, host)
 }
 
-func (dbc *DBConnection) Vacuum() error {
-	_, err := dbc.db.Exec("VACUUM")
-	return err
+// hostOr возвращает host или имя машины configured (см. hostName)
+func hostOr(configured, host string) string {
+	if host != "" {
+		return host
+	}
+	return hostName(configured)
 }
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"cmp"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// dailyKey - группировка по дню, машине, приложению и заголовку. titleKey -
+// title_hash для зашифрованных заголовков, иначе сам заголовок.
+type dailyKey struct {
+	day, host, appID, titleKey, titleHash string
+}
+
+// dailyTotals - суммы по дням, собранные в Go: день записи или сессии -
+// дата начала в текущем часовом поясе, SQLite его не знает
+type dailyTotals map[dailyKey]*model.DailyUsage
+
+// scan добавляет строки (начало или день, host, app_id, title, title_hash,
+// sleep, sessions)
+func (days dailyTotals) scan(rows *sql.Rows) error {
+	for rows.Next() {
+		var du model.DailyUsage
+		var day any
+		var titleHash string
+		if err := rows.Scan(&day, &du.Host, &du.AppID, &du.Title, &titleHash, &du.Sleep, &du.Sessions); err != nil {
+			return err
+		}
+
+		switch d := day.(type) {
+		case time.Time:
+			du.Day = d.Local().Format(dayFormat)
+		case string:
+			du.Day = d
+		}
+
+		days.add(du, titleHash)
+	}
+
+	return rows.Err()
+}
+
+func (days dailyTotals) add(du model.DailyUsage, titleHash string) {
+	key := dailyKey{day: du.Day, host: du.Host, appID: du.AppID, titleKey: titleHash, titleHash: titleHash}
+	if titleHash == "" {
+		key.titleKey = du.Title
+	}
+
+	total, ok := days[key]
+	if !ok {
+		days[key] = &du
+		return
+	}
+
+	total.Sleep += du.Sleep
+	total.Sessions += du.Sessions
+	total.Title = min(total.Title, du.Title)
+}
+
+// sorted возвращает суммы по порядку дня, машины, приложения и заголовка
+func (days dailyTotals) sorted() []model.DailyUsage {
+	keys := make([]dailyKey, 0, len(days))
+	for key := range days {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b dailyKey) int {
+		return cmp.Or(
+			cmp.Compare(a.day, b.day),
+			cmp.Compare(a.host, b.host),
+			cmp.Compare(a.appID, b.appID),
+			cmp.Compare(a.titleKey, b.titleKey),
+		)
+	})
+
+	result := make([]model.DailyUsage, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *days[key])
+	}
+
+	return result
+}
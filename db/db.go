@@ -1,27 +1,111 @@
-// Package db implements SQLite storage.
+// Package db implements SQLite storage and an in-memory alternative.
 package db
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
-// getDBPath возвращает путь к файлу базы данных
-func getDBPath() (string, error) {
+// timeFormat - формат колонок времени: UTC с явным смещением и дробной
+// частью фиксированной длины, поэтому значения сравниваются как строки.
+// Драйвер читает его обратно в time.Time.
+const timeFormat = "2006-01-02 15:04:05.000000000-07:00"
+
+// storedTimeFormats - форматы, в которых время хранилось раньше: по
+// умолчанию драйвер записывал time.Time.String() в часовом поясе записи
+var storedTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+}
+
+// dbTime возвращает значение колонки времени для t
+func dbTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// parseStoredTime разбирает время, записанное любой версией
+func parseStoredTime(s string) (time.Time, error) {
+	// монотонные часы, которые добавляет time.Time.String()
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+
+	for _, layout := range storedTimeFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unknown time format: %q", s)
+}
+
+// DataDir возвращает каталог данных с учетом $XDG_DATA_HOME
+func DataDir() (string, error) {
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return filepath.Join(xdg, "niri-screen-time"), nil
+	}
+
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("failed to get home directory: %w", err)
 	}
 
-	dbDir := filepath.Join(homeDir, ".local", "share", "niri-screen-time")
-	dbPath := filepath.Join(dbDir, "db.db")
+	return filepath.Join(homeDir, ".local", "share", "niri-screen-time"), nil
+}
+
+// DefaultDBPath возвращает путь к файлу базы данных по умолчанию
+func DefaultDBPath() (string, error) {
+	dbDir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dbDir, "db.db"), nil
+}
+
+// ErrMemoryStorage - команде нужен файл базы, а выбрано хранение в памяти
+var ErrMemoryStorage = errors.New("in-memory storage (" + MemoryPath + ") is only supported by the daemon and reports")
+
+// resolveDBPath раскрывает "~/" и подставляет путь по умолчанию для пустой строки
+func resolveDBPath(dbPath string) (string, error) {
+	if dbPath == "" {
+		return DefaultDBPath()
+	}
+
+	if IsMemoryPath(dbPath) {
+		return "", ErrMemoryStorage
+	}
+
+	return ExpandHome(dbPath)
+}
+
+// ExpandHome раскрывает "~/" в начале пути
+func ExpandHome(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		return filepath.Join(homeDir, rest), nil
+	}
+
+	return path, nil
+}
+
+// ensureDir создает каталог для файла базы данных
+func ensureDir(dbPath string) error {
+	dbDir := filepath.Dir(dbPath)
 
 	var perm uint32 = 0755
 
 	if err := os.MkdirAll(dbDir, os.FileMode(perm)); err != nil {
-		return "", fmt.Errorf("failed to create directory %s: %w", dbDir, err)
+		return fmt.Errorf("failed to create directory %s: %w", dbDir, err)
 	}
 
-	return dbPath, nil
+	return nil
 }
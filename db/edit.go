@@ -0,0 +1,299 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"time"
+)
+
+// EditFilter - отбор строк для правки истории, пустое поле не ограничивает.
+// Заголовки сравниваются после расшифровки, поэтому Title проверяется в Go.
+type EditFilter struct {
+	AppID string
+	Title *regexp.Regexp
+	From  *time.Time
+	To    *time.Time
+	// except - заголовок, строки с которым не меняются
+	except string
+}
+
+// EditCount - затронутые строки одной таблицы и их суммарное время
+type EditCount struct {
+	Rows  int
+	Sleep int
+}
+
+// EditResult - что изменила (или изменила бы) правка истории
+type EditResult struct {
+	Samples   EditCount
+	Sessions  EditCount
+	Summaries EditCount
+}
+
+// Total возвращает число затронутых строк во всех таблицах
+func (r EditResult) Total() int {
+	return r.Samples.Rows + r.Sessions.Rows + r.Summaries.Rows
+}
+
+// EditDB - удаление, переименование и скрытие данных в записях, сессиях и
+// суммах по дням. Каждая правка выполняется в одной транзакции, с dryRun
+// она откатывается, а результат показывает, что было бы изменено.
+type EditDB struct {
+	conn *DBConnection
+}
+
+func NewEditDB(conn *DBConnection) *EditDB {
+	return &EditDB{conn: conn}
+}
+
+// editRow - строка, выбранная для правки. Поля day - sessions заполняются
+// только для daily_summary.
+type editRow struct {
+	rowID    int64
+	title    string
+	sleep    int
+	day      string
+	host     string
+	appID    string
+	stored   string
+	hash     string
+	key      string
+	sessions int
+}
+
+// editTables - таблицы с отдельными записями и колонка их времени
+var editTables = []struct{ name, timeColumn string }{
+	{"screen_time", "date"},
+	{"aggregated_screen_time", "started_at"},
+}
+
+// Purge удаляет все строки, подходящие под filter
+func (edb *EditDB) Purge(filter EditFilter, dryRun bool) (EditResult, error) {
+	return edb.edit(filter, dryRun, editOps{
+		row: func(tx *sql.Tx, table string, r editRow) error {
+			_, err := tx.Exec("DELETE FROM "+table+" WHERE rowid = ?", r.rowID) // #nosec G202 -- fixed table names
+			return err
+		},
+		summary: func(tx *sql.Tx, r editRow) error {
+			_, err := tx.Exec("DELETE FROM daily_summary WHERE rowid = ?", r.rowID)
+			return err
+		},
+	})
+}
+
+// RenameApp переносит всю историю приложения oldID на newID. Суммы по
+// дням, совпавшие с уже записанными для newID, складываются.
+func (edb *EditDB) RenameApp(oldID, newID string, dryRun bool) (EditResult, error) {
+	return edb.edit(EditFilter{AppID: oldID}, dryRun, editOps{
+		row: func(tx *sql.Tx, table string, r editRow) error {
+			_, err := tx.Exec("UPDATE "+table+" SET app_id = ? WHERE rowid = ?", newID, r.rowID) // #nosec G202 -- fixed table names
+			return err
+		},
+		summary: func(tx *sql.Tx, r editRow) error {
+			r.appID = newID
+			return replaceSummary(tx, r)
+		},
+	})
+}
+
+// RedactTitles заменяет заголовки строк, подходящих под filter, на
+// placeholder. Освободившиеся страницы базы затираются VACUUM.
+func (edb *EditDB) RedactTitles(filter EditFilter, placeholder string, dryRun bool) (EditResult, error) {
+	stored, hash, err := edb.conn.sealTitle(placeholder)
+	if err != nil {
+		return EditResult{}, err
+	}
+
+	key := hash
+	if key == "" {
+		key = stored
+	}
+
+	// уже скрытые строки не трогаются, иначе сумма, с которой слилась
+	// другая строка, была бы перезаписана
+	filter.except = placeholder
+
+	return edb.edit(filter, dryRun, editOps{
+		row: func(tx *sql.Tx, table string, r editRow) error {
+			// #nosec G202 -- fixed table names
+			_, err := tx.Exec("UPDATE "+table+" SET title = ?, title_hash = ? WHERE rowid = ?", stored, hash, r.rowID)
+			return err
+		},
+		summary: func(tx *sql.Tx, r editRow) error {
+			r.stored, r.hash, r.key = stored, hash, key
+			return replaceSummary(tx, r)
+		},
+	})
+}
+
+// editOps - правка одной строки записей или сессий и одной суммы по дням
+type editOps struct {
+	row     func(tx *sql.Tx, table string, r editRow) error
+	summary func(tx *sql.Tx, r editRow) error
+}
+
+func (edb *EditDB) edit(filter EditFilter, dryRun bool, ops editOps) (EditResult, error) {
+	fn := "EditDB:edit"
+	var result EditResult
+
+	tx, err := edb.conn.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	counts := []*EditCount{&result.Samples, &result.Sessions}
+	for i, table := range editTables {
+		rows, err := edb.selectRows(tx, table.name, table.timeColumn, filter)
+		if err != nil {
+			return result, err
+		}
+
+		for _, r := range rows {
+			if err := ops.row(tx, table.name, r); err != nil {
+				return result, err
+			}
+			counts[i].add(r)
+		}
+	}
+
+	summaries, err := edb.selectSummaries(tx, filter)
+	if err != nil {
+		return result, err
+	}
+
+	for _, r := range summaries {
+		if err := ops.summary(tx, r); err != nil {
+			return result, err
+		}
+		result.Summaries.add(r)
+	}
+
+	if dryRun || result.Total() == 0 {
+		return result, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+
+	// освобожденные страницы могут хранить удаленные заголовки
+	if err := edb.conn.Vacuum(); err != nil {
+		log.Println(fn, err)
+	}
+
+	return result, nil
+}
+
+func (c *EditCount) add(r editRow) {
+	c.Rows++
+	c.Sleep += r.sleep
+}
+
+// editCondition - отбор по приложению и периоду, аргументы: AppID, AppID,
+// начало, начало, конец, конец (пустая строка не ограничивает)
+func editCondition(timeColumn string) string {
+	return "(? = '' OR app_id = ?) AND (? = '' OR " + timeColumn + " >= ?) AND (? = '' OR " + timeColumn + " <= ?)"
+}
+
+func (f EditFilter) args(from, to string) []any {
+	return []any{f.AppID, f.AppID, from, from, to, to}
+}
+
+func (edb *EditDB) selectRows(tx *sql.Tx, table, timeColumn string, filter EditFilter) ([]editRow, error) {
+	var from, to string
+	if filter.From != nil {
+		from = dbTime(*filter.From)
+	}
+	if filter.To != nil {
+		to = dbTime(*filter.To)
+	}
+
+	rows, err := tx.Query(
+		"SELECT rowid, title, sleep FROM "+table+" WHERE "+editCondition(timeColumn), // #nosec G202 -- fixed identifiers
+		filter.args(from, to)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return edb.matchRows(rows, filter, func(r *editRow) []any {
+		return []any{&r.rowID, &r.stored, &r.sleep}
+	})
+}
+
+func (edb *EditDB) selectSummaries(tx *sql.Tx, filter EditFilter) ([]editRow, error) {
+	var from, to string
+	// суммы хранятся за целые дни, день начала берется, только если он
+	// попадает в период целиком
+	if filter.From != nil {
+		from, _ = summaryDays(*filter.From, *filter.From)
+	}
+	if filter.To != nil {
+		to = filter.To.Format(dayFormat)
+	}
+
+	rows, err := tx.Query(
+		"SELECT rowid, day, host, app_id, title, title_hash, title_key, sleep, sessions FROM daily_summary WHERE "+editCondition("day"),
+		filter.args(from, to)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return edb.matchRows(rows, filter, func(r *editRow) []any {
+		return []any{&r.rowID, &r.day, &r.host, &r.appID, &r.stored, &r.hash, &r.key, &r.sleep, &r.sessions}
+	})
+}
+
+// matchRows читает строки целиком (до правки) и оставляет те, чей
+// расшифрованный заголовок подходит под filter.Title
+func (edb *EditDB) matchRows(rows *sql.Rows, filter EditFilter, dest func(r *editRow) []any) ([]editRow, error) {
+	fn := "EditDB:matchRows"
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	var matched []editRow
+	for rows.Next() {
+		var r editRow
+		if err := rows.Scan(dest(&r)...); err != nil {
+			return nil, err
+		}
+
+		title, err := edb.conn.openTitle(r.stored)
+		if err != nil {
+			return nil, err
+		}
+		r.title = title
+
+		if title == filter.except && filter.except != "" {
+			continue
+		}
+		if filter.Title != nil && !filter.Title.MatchString(title) {
+			continue
+		}
+		matched = append(matched, r)
+	}
+
+	return matched, rows.Err()
+}
+
+// replaceSummary заменяет строку daily_summary измененной r, совпавшая
+// по ключу строка складывается с ней
+func replaceSummary(tx *sql.Tx, r editRow) error {
+	if _, err := tx.Exec("DELETE FROM daily_summary WHERE rowid = ?", r.rowID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(upsertSummaryQuery, r.day, r.host, r.appID, r.stored, r.hash, r.key, r.sleep, r.sessions)
+	return err
+}
@@ -0,0 +1,309 @@
+package db
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// ExportDB - потоковая выгрузка сессий и сумм за период
+type ExportDB struct {
+	conn   *DBConnection
+	maxGap time.Duration
+}
+
+// NewExportDB создает выгрузку базы conn. Еще не агрегированные записи
+// собираются в сессии так же, как это делает агрегатор: maxGap -
+// наибольший разрыв между записями одной сессии.
+func NewExportDB(conn *DBConnection, maxGap time.Duration) *ExportDB {
+	return &ExportDB{conn: conn, maxGap: maxGap}
+}
+
+// pendingSession - сессия из еще не агрегированных записей. storedTitle и
+// titleHash - заголовок в том виде, в котором он хранится в базе.
+type pendingSession struct {
+	model.AggregatedScreenTime
+	storedTitle string
+	titleHash   string
+}
+
+const exportSessionsQuery = `
+	SELECT started_at, ended_at, host, app_id, title, sleep, source FROM aggregated_screen_time
+	WHERE started_at >= ? AND started_at <= ? AND ended_at > ?
+	ORDER BY started_at`
+
+const exportPendingQuery = `
+	SELECT date, host, app_id, title, title_hash, sleep FROM screen_time
+	WHERE date BETWEEN ? AND ?
+	ORDER BY date, id`
+
+// Сессии и суммы по дням, начатые в периоде. Еще не агрегированные записи
+// добавляются в Go.
+const exportUsageSource = `
+	SELECT app_id, sleep, 1 AS sessions FROM aggregated_screen_time
+	WHERE started_at BETWEEN ? AND ?
+	UNION ALL
+	SELECT app_id, sleep, sessions FROM daily_summary
+	WHERE day BETWEEN ? AND ?`
+
+const exportAppsQuery = `
+	SELECT app_id, SUM(sleep), SUM(sessions) FROM (` + exportUsageSource + `)
+	GROUP BY app_id`
+
+// День сессии - дата начала в текущем часовом поясе, поэтому он
+// считается в Go, а не в SQL
+const exportTimedQuery = `
+	SELECT started_at, host, app_id, title, title_hash, sleep, 1 FROM aggregated_screen_time
+	WHERE started_at BETWEEN ? AND ?`
+
+const exportSummariesQuery = `
+	SELECT day, host, app_id, title, title_hash, sleep, sessions FROM daily_summary
+	WHERE day BETWEEN ? AND ?`
+
+// Sessions вызывает each для каждой сессии, пересекающей период, по
+// порядку начала. Сессии не обрезаются.
+func (edb *ExportDB) Sessions(
+	from,
+	to *time.Time,
+	each func(model.AggregatedScreenTime) error,
+) error {
+	fn := "ExportDB:Sessions"
+
+	pending, err := edb.pendingSessions(*from, *to)
+	if err != nil {
+		return err
+	}
+	slices.SortStableFunc(pending, func(a, b pendingSession) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+
+	rows, err := edb.conn.db.Query(exportSessionsQuery, dbTime(from.Add(-maxSessionLength)), dbTime(*to), dbTime(*from))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for rows.Next() {
+		var ast model.AggregatedScreenTime
+		if err := rows.Scan(&ast.StartedAt, &ast.EndedAt, &ast.Host, &ast.AppID, &ast.Title, &ast.Sleep, &ast.Source); err != nil {
+			return err
+		}
+		if ast.Title, err = edb.conn.openTitle(ast.Title); err != nil {
+			return err
+		}
+		ast.StartedAt, ast.EndedAt = ast.StartedAt.Local(), ast.EndedAt.Local()
+
+		// несохраненные сессии, начатые раньше, идут первыми
+		for len(pending) > 0 && pending[0].StartedAt.Before(ast.StartedAt) {
+			if err := each(pending[0].AggregatedScreenTime); err != nil {
+				return err
+			}
+			pending = pending[1:]
+		}
+
+		if err := each(ast); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ps := range pending {
+		if err := each(ps.AggregatedScreenTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pendingSessions собирает записи screen_time за период в сессии так же,
+// как агрегатор. Таких записей немного: агрегатор забирает их каждые
+// несколько минут.
+func (edb *ExportDB) pendingSessions(from, to time.Time) ([]pendingSession, error) {
+	fn := "ExportDB:pendingSessions"
+
+	rows, err := edb.conn.db.Query(exportPendingQuery, dbTime(from), dbTime(to))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	// последняя открытая сессия каждой машины
+	open := map[string]int{}
+	sessions := []pendingSession{}
+	for rows.Next() {
+		var st model.ScreenTime
+		var storedTitle, titleHash string
+		if err := rows.Scan(&st.Date, &st.Host, &st.AppID, &storedTitle, &titleHash, &st.Sleep); err != nil {
+			return nil, err
+		}
+		if st.Title, err = edb.conn.openTitle(storedTitle); err != nil {
+			return nil, err
+		}
+		st.Date = st.Date.Local()
+
+		if i, ok := open[st.Host]; ok && sessions[i].Continues(st, edb.maxGap) {
+			sessions[i].AddScreenTime(st)
+			continue
+		}
+
+		open[st.Host] = len(sessions)
+		sessions = append(sessions, pendingSession{
+			AggregatedScreenTime: model.NewAggregatedScreenTimeFromScreenTime(st),
+			storedTitle:          storedTitle,
+			titleHash:            titleHash,
+		})
+	}
+
+	return sessions, rows.Err()
+}
+
+// Daily вызывает each для каждой пары приложение/заголовок каждого дня
+// по порядку дней. Суммы собираются в памяти, их не больше, чем строк
+// выгрузки.
+func (edb *ExportDB) Daily(
+	from,
+	to *time.Time,
+	each func(model.DailyUsage) error,
+) error {
+	days := dailyTotals{}
+
+	args := edb.usageArgs(*from, *to)
+	if err := edb.collectDaily(days, exportTimedQuery, args[:2]...); err != nil {
+		return err
+	}
+	if err := edb.collectDaily(days, exportSummariesQuery, args[2:]...); err != nil {
+		return err
+	}
+
+	pending, err := edb.pendingSessions(*from, *to)
+	if err != nil {
+		return err
+	}
+	for _, ps := range pending {
+		days.add(model.DailyUsage{
+			Day:      ps.StartedAt.Format(dayFormat),
+			Host:     ps.Host,
+			AppID:    ps.AppID,
+			Title:    ps.storedTitle,
+			Sleep:    ps.Sleep,
+			Sessions: 1,
+		}, ps.titleHash)
+	}
+
+	for _, du := range days.sorted() {
+		var err error
+		if du.Title, err = edb.conn.openTitle(du.Title); err != nil {
+			return err
+		}
+		if err := each(du); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (edb *ExportDB) collectDaily(days dailyTotals, query string, args ...any) error {
+	fn := "ExportDB:collectDaily"
+
+	rows, err := edb.conn.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	return days.scan(rows)
+}
+
+// Apps вызывает each для каждого приложения, по убыванию времени
+func (edb *ExportDB) Apps(
+	from,
+	to *time.Time,
+	each func(model.AppUsage) error,
+) error {
+	apps, err := edb.collectApps(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	pending, err := edb.pendingSessions(*from, *to)
+	if err != nil {
+		return err
+	}
+	for _, ps := range pending {
+		au := apps[ps.AppID]
+		au.AppID = ps.AppID
+		au.Sleep += ps.Sleep
+		au.Sessions++
+		apps[ps.AppID] = au
+	}
+
+	result := make([]model.AppUsage, 0, len(apps))
+	for _, au := range apps {
+		result = append(result, au)
+	}
+	slices.SortFunc(result, func(a, b model.AppUsage) int {
+		return cmp.Or(cmp.Compare(b.Sleep, a.Sleep), cmp.Compare(a.AppID, b.AppID))
+	})
+
+	for _, au := range result {
+		if err := each(au); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (edb *ExportDB) collectApps(from, to time.Time) (map[string]model.AppUsage, error) {
+	fn := "ExportDB:collectApps"
+
+	rows, err := edb.conn.db.Query(exportAppsQuery, edb.usageArgs(from, to)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	apps := map[string]model.AppUsage{}
+	for rows.Next() {
+		var au model.AppUsage
+		if err := rows.Scan(&au.AppID, &au.Sleep, &au.Sessions); err != nil {
+			return nil, err
+		}
+		apps[au.AppID] = au
+	}
+
+	return apps, rows.Err()
+}
+
+func (*ExportDB) usageArgs(from, to time.Time) []any {
+	firstDay, lastDay := summaryDays(from, to)
+
+	return []any{dbTime(from), dbTime(to), firstDay, lastDay}
+}
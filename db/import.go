@@ -0,0 +1,262 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// ImportResult - итог импорта сессий из другого трекера
+type ImportResult struct {
+	// Imported - добавленные сессии и их суммарное время
+	Imported int
+	Sleep    int
+	// Trimmed - сессии, от которых отрезано уже записанное время
+	Trimmed int
+	// Duplicates - сессии, полностью совпавшие с уже записанными
+	Duplicates int
+	// RolledUp - сессии за дни, уже свернутые в daily_summary
+	RolledUp int
+}
+
+// minImportPiece - более короткие остатки после вычитания пересечений
+// не сохраняются
+const minImportPiece = time.Second
+
+// ImportDB - добавление сессий из других трекеров без повторов
+type ImportDB struct {
+	conn *DBConnection
+}
+
+func NewImportDB(conn *DBConnection) *ImportDB {
+	return &ImportDB{conn: conn}
+}
+
+// Import сохраняет sessions (отсортированные по началу, не переходящие
+// через полночь) в одной транзакции. Время, уже покрытое сессиями той же
+// машины (в том числе добавленными этим же импортом), вычитается, поэтому
+// повторный импорт ничего не добавляет. Дни, уже свернутые в
+// daily_summary, пропускаются целиком. С dryRun транзакция откатывается.
+func (idb *ImportDB) Import(sessions []model.AggregatedScreenTime, dryRun bool) (ImportResult, error) {
+	fn := "ImportDB:Import"
+	var result ImportResult
+
+	tx, err := idb.conn.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	si, err := newSessionImporter(idb.conn, tx)
+	if err != nil {
+		return result, err
+	}
+	defer si.close()
+
+	for _, ast := range sessions {
+		ast.Host = idb.conn.hostOr(ast.Host)
+		if err := si.add(ast); err != nil {
+			return si.result, err
+		}
+	}
+	result = si.result
+
+	if dryRun {
+		return result, nil
+	}
+
+	return result, tx.Commit()
+}
+
+// sessionImporter добавляет сессии в транзакцию, вычитая время, уже
+// покрытое сессиями той же машины. Используется импортом и слиянием баз.
+type sessionImporter struct {
+	conn         *DBConnection
+	tx           *sql.Tx
+	stmt         *sql.Stmt
+	rolledUpDays map[string]bool
+	result       ImportResult
+}
+
+func newSessionImporter(conn *DBConnection, tx *sql.Tx) (*sessionImporter, error) {
+	stmt, err := tx.Prepare(insertAggregatedQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionImporter{
+		conn:         conn,
+		tx:           tx,
+		stmt:         stmt,
+		rolledUpDays: map[string]bool{},
+	}, nil
+}
+
+func (si *sessionImporter) close() {
+	if err := si.stmt.Close(); err != nil {
+		log.Println("sessionImporter:close", err)
+	}
+}
+
+// add сохраняет сессию ast (с заполненным host) без уже записанного времени
+func (si *sessionImporter) add(ast model.AggregatedScreenTime) error {
+	day := ast.StartedAt.Format(dayFormat)
+	key := ast.Host + "\x00" + day
+
+	rolledUp, ok := si.rolledUpDays[key]
+	if !ok {
+		var err error
+		if rolledUp, err = isRolledUp(si.tx, ast.Host, day); err != nil {
+			return err
+		}
+		si.rolledUpDays[key] = rolledUp
+	}
+	if rolledUp {
+		si.result.RolledUp++
+		return nil
+	}
+
+	covered, err := coveredIntervals(si.tx, ast.Host, ast.StartedAt, ast.EndedAt)
+	if err != nil {
+		return err
+	}
+
+	// без пересечений сессия сохраняется целиком, даже короткая
+	pieces := []model.AggregatedScreenTime{ast}
+	if len(covered) > 0 {
+		pieces = subtractIntervals(ast.StartedAt, ast.EndedAt, covered)
+	}
+
+	switch {
+	case len(pieces) == 0:
+		si.result.Duplicates++
+		return nil
+	case len(covered) > 0:
+		si.result.Trimmed++
+	}
+
+	for _, piece := range pieces {
+		if len(covered) > 0 {
+			piece.AppID, piece.Title, piece.Source, piece.Host = ast.AppID, ast.Title, ast.Source, ast.Host
+			piece.Sleep = int(piece.EndedAt.Sub(piece.StartedAt).Milliseconds())
+		}
+
+		if err := si.insert(piece); err != nil {
+			return err
+		}
+
+		si.result.Imported++
+		si.result.Sleep += piece.Sleep
+	}
+
+	return nil
+}
+
+func (si *sessionImporter) insert(ast model.AggregatedScreenTime) error {
+	title, titleHash, err := si.conn.sealTitle(ast.Title)
+	if err != nil {
+		return err
+	}
+
+	_, err = si.stmt.Exec(
+		dbTime(ast.EndedAt), dbTime(ast.StartedAt), dbTime(ast.EndedAt),
+		ast.AppID, title, titleHash, ast.Sleep, ast.Source, ast.Host,
+	)
+	return err
+}
+
+func isRolledUp(tx *sql.Tx, host, day string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM daily_summary WHERE host = ? AND day = ?", host, day).Scan(&count)
+
+	return count > 0, err
+}
+
+// coveredIntervals возвращает сессии и еще не свернутые записи host,
+// пересекающие [start, end), по порядку начала
+func coveredIntervals(tx *sql.Tx, host string, start, end time.Time) ([]model.AggregatedScreenTime, error) {
+	fn := "db:coveredIntervals"
+
+	rows, err := tx.Query(`
+		SELECT started_at, ended_at, 0 FROM aggregated_screen_time
+		WHERE host = ? AND started_at >= ? AND started_at < ? AND ended_at > ?
+		UNION ALL
+		SELECT date, date, sleep FROM screen_time
+		WHERE host = ? AND date >= ? AND date < ?
+		ORDER BY 1`,
+		host, dbTime(start.Add(-maxSessionLength)), dbTime(end), dbTime(start),
+		host, dbTime(start.Add(-maxSessionLength)), dbTime(end),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	var covered []model.AggregatedScreenTime
+	for rows.Next() {
+		var ast model.AggregatedScreenTime
+		var sampleSleep int
+		if err := rows.Scan(&ast.StartedAt, &ast.EndedAt, &sampleSleep); err != nil {
+			return nil, err
+		}
+
+		if sampleSleep > 0 {
+			ast.EndedAt = ast.StartedAt.Add(time.Duration(sampleSleep) * time.Millisecond)
+			if !ast.EndedAt.After(start) {
+				continue
+			}
+		}
+
+		covered = append(covered, ast)
+	}
+
+	return covered, rows.Err()
+}
+
+// subtractIntervals возвращает части [start, end), не покрытые covered
+// (отсортированными по началу)
+func subtractIntervals(start, end time.Time, covered []model.AggregatedScreenTime) []model.AggregatedScreenTime {
+	var pieces []model.AggregatedScreenTime
+
+	cursor := start
+	for _, c := range covered {
+		if c.StartedAt.After(cursor) {
+			pieces = appendPiece(pieces, cursor, minTime(c.StartedAt, end))
+		}
+		if c.EndedAt.After(cursor) {
+			cursor = c.EndedAt
+		}
+		if !cursor.Before(end) {
+			return pieces
+		}
+	}
+
+	return appendPiece(pieces, cursor, end)
+}
+
+func appendPiece(pieces []model.AggregatedScreenTime, start, end time.Time) []model.AggregatedScreenTime {
+	if end.Sub(start) < minImportPiece {
+		return pieces
+	}
+
+	return append(pieces, model.AggregatedScreenTime{StartedAt: start, EndedAt: end})
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,352 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// journalEntry - строка журнала, заголовок хранится так же, как в базе
+// (зашифрованным, если включено шифрование)
+type journalEntry struct {
+	Date  time.Time `json:"date"`
+	AppID string    `json:"app_id"`
+	Title string    `json:"title"`
+	Sleep int       `json:"sleep"`
+	Host  string    `json:"host,omitempty"`
+}
+
+// SampleJournal - журнал записей, собранных демоном, но еще не
+// сохраненных в базе (файл <база>.journal). Записи дописываются в конец по
+// одной строке JSON, сохраненные удаляются из начала. После падения демона
+// журнал воспроизводится при следующем запуске.
+type SampleJournal struct {
+	conn  *DBConnection
+	path  string
+	file  *os.File
+	count int
+	mutex sync.Mutex
+}
+
+// OpenSampleJournal открывает журнал базы conn. Строка, оборванная
+// падением на середине записи, отбрасывается.
+func OpenSampleJournal(conn *DBConnection) (*SampleJournal, error) {
+	j := &SampleJournal{conn: conn, path: JournalPath(conn.Path())}
+
+	count, valid, err := j.scan()
+	if err != nil {
+		return nil, err
+	}
+	j.count = count
+
+	if !valid {
+		log.Println("OpenSampleJournal", "dropping a damaged entry from", j.path)
+		if err := j.rewrite(-1); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// JournalPath возвращает путь к журналу базы dbPath
+func JournalPath(dbPath string) string {
+	return dbPath + ".journal"
+}
+
+// Path возвращает путь к файлу журнала
+func (j *SampleJournal) Path() string {
+	return j.path
+}
+
+// Len возвращает число записей в журнале
+func (j *SampleJournal) Len() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.count
+}
+
+// Append дописывает запись в конец журнала. Запись попадает в файл сразу,
+// на диск - не позже следующего Sync.
+func (j *SampleJournal) Append(st model.ScreenTime) error {
+	title, _, err := j.conn.sealTitle(st.Title)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(journalEntry{
+		Date:  st.Date,
+		AppID: st.AppID,
+		Title: title,
+		Sleep: st.Sleep,
+		Host:  st.Host,
+	})
+	if err != nil {
+		return err
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	j.count++
+
+	return nil
+}
+
+// Sync сбрасывает журнал на диск
+func (j *SampleJournal) Sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.file.Sync()
+}
+
+// Next читает до limit первых записей журнала и возвращает те из них,
+// которых еще нет в базе, и число прочитанных записей (для Drop). Запись
+// уже есть в базе, если демон упал между сохранением и Drop: она не новее
+// последней записи screen_time этой машины или целиком лежит до конца ее
+// последней сессии.
+func (j *SampleJournal) Next(limit int) ([]model.ScreenTime, int, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var entries []journalEntry
+	_, err := j.read(limit, func(line []byte) error {
+		entry, ok := parseJournalEntry(line)
+		if !ok {
+			return fmt.Errorf("damaged journal entry: %q", line)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil || len(entries) == 0 {
+		return nil, 0, err
+	}
+
+	lastSample, lastSession, err := j.stored()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]model.ScreenTime, 0, len(entries))
+	for _, entry := range entries {
+		st := model.ScreenTime{Date: entry.Date, AppID: entry.AppID, Sleep: entry.Sleep, Host: entry.Host}
+		if !st.Date.After(lastSample) || !st.End().After(lastSession) {
+			continue
+		}
+
+		if st.Title, err = j.conn.openTitle(entry.Title); err != nil {
+			return nil, 0, err
+		}
+		records = append(records, st)
+	}
+
+	return records, len(entries), nil
+}
+
+// Drop удаляет n первых записей журнала, обычно уже сохраненных в базе
+func (j *SampleJournal) Drop(n int) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if n <= 0 {
+		return nil
+	}
+
+	if n >= j.count {
+		// все записи сохранены, файл достаточно обрезать
+		if err := j.file.Truncate(0); err != nil {
+			return err
+		}
+		j.count = 0
+		return nil
+	}
+
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	// журнал открывается снова и тогда, когда переписать его не удалось
+	err := j.rewrite(n)
+	if err == nil {
+		j.count -= n
+	}
+	if openErr := j.open(); err == nil {
+		err = openErr
+	}
+
+	return err
+}
+
+// Close закрывает файл журнала, несохраненные записи остаются в нем до
+// следующего запуска
+func (j *SampleJournal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.file.Sync(); err != nil {
+		_ = j.file.Close()
+		return err
+	}
+
+	return j.file.Close()
+}
+
+func (j *SampleJournal) open() error {
+	var perm os.FileMode = 0600
+
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	j.file = file
+
+	return nil
+}
+
+func parseJournalEntry(line []byte) (journalEntry, bool) {
+	var entry journalEntry
+	err := json.Unmarshal(line, &entry)
+
+	return entry, err == nil
+}
+
+// scan считает целые записи журнала. valid ложно, если в файле есть
+// оборванная или испорченная строка.
+func (j *SampleJournal) scan() (count int, valid bool, err error) {
+	valid = true
+	torn, err := j.read(-1, func(line []byte) error {
+		if _, ok := parseJournalEntry(line); ok {
+			count++
+		} else {
+			valid = false
+		}
+		return nil
+	})
+
+	return count, valid && !torn, err
+}
+
+// read вызывает each для первых limit строк журнала (всех, если limit < 0).
+// Строка без перевода строки в конце - оборванная запись, она пропускается
+// (torn).
+func (j *SampleJournal) read(limit int, each func(line []byte) error) (torn bool, err error) {
+	file, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println("SampleJournal:read", err)
+		}
+	}()
+
+	reader := bufio.NewReader(file)
+	for n := 0; limit < 0 || n < limit; n++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return len(line) > 0, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if err := each(bytes.TrimSuffix(line, []byte("\n"))); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// rewrite заменяет журнал копией без первых skip целых записей и без
+// испорченных строк (skip < 0 - только без испорченных строк). Копия
+// появляется под именем журнала только целиком.
+func (j *SampleJournal) rewrite(skip int) error {
+	var perm os.FileMode = 0600
+
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	_, err = j.read(-1, func(line []byte) error {
+		if _, ok := parseJournalEntry(line); !ok {
+			return nil
+		}
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+		return writer.WriteByte('\n')
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to rewrite journal: %w", err)
+	}
+
+	return os.Rename(tmpPath, j.path)
+}
+
+// stored возвращает время последней записи screen_time этой машины и
+// конец ее последней сессии, собранной демоном
+func (j *SampleJournal) stored() (lastSample, lastSession time.Time, err error) {
+	host := j.conn.Host()
+
+	if lastSample, err = j.maxTime("SELECT MAX(date) FROM screen_time WHERE host = ?", host); err != nil {
+		return lastSample, lastSession, err
+	}
+
+	lastSession, err = j.maxTime(
+		"SELECT MAX(ended_at) FROM aggregated_screen_time WHERE host = ? AND source = ''",
+		host,
+	)
+
+	return lastSample, lastSession, err
+}
+
+func (j *SampleJournal) maxTime(query string, args ...any) (time.Time, error) {
+	var value sql.NullString
+	if err := j.conn.db.QueryRow(query, args...).Scan(&value); err != nil {
+		return time.Time{}, err
+	}
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+
+	return parseStoredTime(value.String)
+}
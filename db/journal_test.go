@@ -0,0 +1,222 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+var journalStart = time.Date(2025, time.March, 10, 10, 0, 0, 0, time.UTC)
+
+// openTestJournal создает пустую базу во временном каталоге и ее журнал
+func openTestJournal(t *testing.T) (*DBConnection, *SampleJournal) {
+	t.Helper()
+
+	conn := openTestDB(t)
+
+	return conn, reopenJournal(t, conn)
+}
+
+// openTestDB создает пустую базу машины desktop во временном каталоге
+func openTestDB(t *testing.T) *DBConnection {
+	t.Helper()
+
+	conn, err := NewDBConnection(filepath.Join(t.TempDir(), "db.db"))
+	if err != nil {
+		t.Fatalf("NewDBConnection: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	conn.SetHost("desktop")
+	if err := conn.InitTables(); err != nil {
+		t.Fatalf("InitTables: %v", err)
+	}
+
+	return conn
+}
+
+func reopenJournal(t *testing.T, conn *DBConnection) *SampleJournal {
+	t.Helper()
+
+	j, err := OpenSampleJournal(conn)
+	if err != nil {
+		t.Fatalf("OpenSampleJournal: %v", err)
+	}
+
+	return j
+}
+
+// appendSamples дописывает в журнал секундные записи приложений apps
+func appendSamples(t *testing.T, j *SampleJournal, apps ...string) {
+	t.Helper()
+
+	for i, app := range apps {
+		st := model.ScreenTime{
+			Date:  journalStart.Add(time.Duration(i) * time.Second),
+			AppID: app,
+			Title: "title",
+			Sleep: 1000,
+			Host:  "desktop",
+		}
+		if err := j.Append(st); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+// nextApps возвращает приложения всех записей журнала по порядку
+func nextApps(t *testing.T, j *SampleJournal) []string {
+	t.Helper()
+
+	records, _, err := j.Next(-1)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+
+	var apps []string
+	for _, st := range records {
+		apps = append(apps, st.AppID)
+	}
+
+	return apps
+}
+
+func TestSampleJournalRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage дописывается в журнал после двух целых записей
+		damage string
+		want   []string
+	}{
+		{name: "intact journal", want: []string{"kitty", "firefox"}},
+		{name: "torn last line", damage: `{"date":"2025-03-10T10:00:02Z","app_id":"mp`, want: []string{"kitty", "firefox"}},
+		{name: "damaged line", damage: "not json\n", want: []string{"kitty", "firefox"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, j := openTestJournal(t)
+			appendSamples(t, j, "kitty", "firefox")
+			if err := j.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			file, err := os.OpenFile(j.Path(), os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatalf("OpenFile: %v", err)
+			}
+			if _, err := file.WriteString(tt.damage); err != nil {
+				t.Fatalf("WriteString: %v", err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			j = reopenJournal(t, conn)
+			defer func() {
+				if err := j.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			}()
+
+			if j.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", j.Len(), len(tt.want))
+			}
+			if apps := nextApps(t, j); !slices.Equal(apps, tt.want) {
+				t.Errorf("Next() = %v, want %v", apps, tt.want)
+			}
+
+			// испорченная строка удалена из файла, новые записи идут с новой строки
+			appendSamples(t, j, "kitty", "firefox", "mpv")
+			data, err := os.ReadFile(j.Path())
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if lines := bytes.Count(data, []byte("\n")); lines != 5 || !bytes.HasSuffix(data, []byte("\n")) {
+				t.Errorf("journal has %d lines:\n%s", lines, data)
+			}
+		})
+	}
+}
+
+func TestSampleJournalDrop(t *testing.T) {
+	tests := []struct {
+		name string
+		drop int
+		want []string
+	}{
+		{name: "nothing", drop: 0, want: []string{"a", "b", "c", "d"}},
+		{name: "first records", drop: 2, want: []string{"c", "d"}},
+		{name: "all but one", drop: 3, want: []string{"d"}},
+		{name: "all records", drop: 4},
+		{name: "more than stored", drop: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, j := openTestJournal(t)
+			appendSamples(t, j, "a", "b", "c", "d")
+
+			if err := j.Drop(tt.drop); err != nil {
+				t.Fatalf("Drop: %v", err)
+			}
+			if j.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", j.Len(), len(tt.want))
+			}
+			if apps := nextApps(t, j); !slices.Equal(apps, tt.want) {
+				t.Errorf("Next() = %v, want %v", apps, tt.want)
+			}
+
+			// после Drop журнал открыт для записи и переживает перезапуск
+			appendSamples(t, j, "a", "b", "c", "d", "e")
+			if err := j.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			j = reopenJournal(t, conn)
+			defer func() {
+				if err := j.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			}()
+
+			if want := len(tt.want) + 5; j.Len() != want {
+				t.Errorf("Len() after reopening = %d, want %d", j.Len(), want)
+			}
+		})
+	}
+}
+
+func TestSampleJournalNextSkipsStored(t *testing.T) {
+	conn, j := openTestJournal(t)
+	defer func() {
+		if err := j.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	}()
+	appendSamples(t, j, "a", "b", "c")
+
+	// демон упал после сохранения первых двух записей, но до Drop
+	records, _, err := j.Next(2)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if err := NewScreenTimeDB(conn).BulkInsert(records); err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+
+	records, read, err := j.Next(-1)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if read != 3 || len(records) != 1 || records[0].AppID != "c" {
+		t.Errorf("Next() = %+v, %d, want only c of 3", records, read)
+	}
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ErrLocked - базу использует запущенный демон
+var ErrLocked = errors.New("database is in use by a running daemon")
+
+// Lock - эксклюзивная блокировка базы, которую держит демон. Блокировка
+// снимается системой при завершении процесса, поэтому после падения
+// демона файл не мешает следующему запуску.
+type Lock struct {
+	file *os.File
+}
+
+// LockDatabase блокирует базу dbPath (файл dbPath.lock) без ожидания,
+// если базу уже заблокировал другой процесс, возвращает ErrLocked
+func LockDatabase(dbPath string) (*Lock, error) {
+	dbPath, err := resolveDBPath(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureDir(dbPath); err != nil {
+		return nil, err
+	}
+
+	var perm os.FileMode = 0600
+
+	file, err := os.OpenFile(dbPath+".lock", os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("failed to lock database: %w", err)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// isLocked сообщает, держит ли базу dbPath запущенный демон. Файл
+// блокировки не создается; если проверить не удалось, база считается
+// занятой.
+func isLocked(dbPath string) bool {
+	file, err := os.Open(dbPath + ".lock")
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		return true
+	}
+	_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	return false
+}
+
+// Unlock снимает блокировку
+func (l *Lock) Unlock() error {
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN); err != nil {
+		_ = l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
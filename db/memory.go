@@ -0,0 +1,161 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// MemoryPath - путь базы, вместо которой данные хранятся в памяти процесса
+// (-db :memory:)
+const MemoryPath = ":memory:"
+
+// IsMemoryPath сообщает, выбрано ли хранение в памяти
+func IsMemoryPath(path string) bool {
+	return path == MemoryPath
+}
+
+// MemoryStore - Storage в памяти процесса для запусков без файла базы и
+// для встраивания. Данные пропадают вместе с процессом, сумм по дням нет,
+// заголовки не шифруются.
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	host     string
+	lastID   int
+	samples  []model.ScreenTime
+	sessions []model.AggregatedScreenTime
+	state    AggregatorState
+}
+
+// NewMemoryStore создает пустое хранилище, записи без host помечаются
+// host (пустой - имя этой машины)
+func NewMemoryStore(host string) *MemoryStore {
+	return &MemoryStore{host: host}
+}
+
+// BulkInsert добавляет записи, id выдаются по порядку, как в screen_time
+func (ms *MemoryStore) BulkInsert(records []model.ScreenTime) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, st := range records {
+		ms.lastID++
+		st.ID = ms.lastID
+		st.Host = hostOr(ms.host, st.Host)
+		ms.samples = append(ms.samples, st)
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) State() (AggregatorState, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	state := ms.state
+	for _, st := range ms.samples {
+		if st.ID > state.LastID {
+			state.Pending++
+		}
+	}
+
+	return state, nil
+}
+
+func (ms *MemoryStore) NextBatch(afterID, limit int) ([]model.ScreenTime, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	var results []model.ScreenTime
+	for _, st := range ms.samples {
+		if len(results) == limit {
+			break
+		}
+		if st.ID > afterID {
+			results = append(results, st)
+		}
+	}
+
+	return results, nil
+}
+
+// CommitBatch сохраняет сессии и удаляет записи с id в (afterID, lastID]
+func (ms *MemoryStore) CommitBatch(
+	sessions []model.AggregatedScreenTime,
+	afterID int,
+	lastID int,
+) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, ast := range sessions {
+		ast.Host = hostOr(ms.host, ast.Host)
+		ms.sessions = append(ms.sessions, ast)
+	}
+
+	kept := ms.samples[:0]
+	samples := 0
+	for _, st := range ms.samples {
+		if st.ID > afterID && st.ID <= lastID {
+			samples++
+			continue
+		}
+		kept = append(kept, st)
+	}
+	ms.samples = kept
+
+	now := time.Now()
+	ms.state.LastID = lastID
+	ms.state.Batches++
+	ms.state.Samples += samples
+	ms.state.Sessions += len(sessions)
+	ms.state.UpdatedAt = &now
+
+	return nil
+}
+
+// ForEach вызывает each для каждой записи и сессии за период, сессии на
+// границах учитываются частью, попавшей в период (как UsageDB.ForEach)
+func (ms *MemoryStore) ForEach(
+	from,
+	to *time.Time,
+	filter UsageFilter,
+	each func(model.ScreenTime) error,
+) error {
+	ms.mutex.RLock()
+	samples := append([]model.ScreenTime(nil), ms.samples...)
+	sessions := append([]model.AggregatedScreenTime(nil), ms.sessions...)
+	ms.mutex.RUnlock()
+
+	// each вызывается без блокировки, чтобы он мог писать в хранилище
+	for _, st := range samples {
+		if !filter.match(st.AppID, st.Host) || st.Date.Before(*from) || st.Date.After(*to) {
+			continue
+		}
+		if err := each(model.ScreenTime{AppID: st.AppID, Title: st.Title, Sleep: st.Sleep, Host: st.Host}); err != nil {
+			return err
+		}
+	}
+
+	for _, ast := range sessions {
+		if !filter.match(ast.AppID, ast.Host) {
+			continue
+		}
+
+		sleep := ast.ClippedSleep(*from, *to)
+		if sleep == 0 {
+			continue
+		}
+
+		if err := each(model.ScreenTime{AppID: ast.AppID, Title: ast.Title, Sleep: sleep, Host: ast.Host}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f UsageFilter) match(appID, host string) bool {
+	return (f.AppID == "" || f.AppID == appID) && (f.Host == "" || f.Host == host)
+}
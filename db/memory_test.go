@@ -0,0 +1,243 @@
+package db_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/aggregatemanager"
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+const testMaxGap = time.Second
+
+var testDay = time.Date(2025, time.March, 10, 10, 0, 0, 0, time.UTC)
+
+// sample - запись длиной sleep мс, начатая через offset после testDay
+func sample(offset time.Duration, appID, title string, sleep int) model.ScreenTime {
+	return model.ScreenTime{Date: testDay.Add(offset), AppID: appID, Title: title, Sleep: sleep}
+}
+
+// usage собирает время всех записей и сессий хранилища за период
+func usage(t *testing.T, store *db.MemoryStore, from, to time.Time, filter db.UsageFilter) []model.ScreenTime {
+	t.Helper()
+
+	var result []model.ScreenTime
+	err := store.ForEach(&from, &to, filter, func(st model.ScreenTime) error {
+		result = append(result, st)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach: %v", err)
+	}
+
+	return result
+}
+
+func TestMemoryStoreAggregation(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []model.ScreenTime
+		// sleeps - время получившихся сессий по порядку
+		sleeps []int
+	}{
+		{
+			name: "consecutive samples of one window",
+			samples: []model.ScreenTime{
+				sample(0, "kitty", "vim", 1000),
+				sample(time.Second, "kitty", "vim", 1000),
+				sample(2*time.Second, "kitty", "vim", 1000),
+			},
+			sleeps: []int{3000},
+		},
+		{
+			name: "title change starts a session",
+			samples: []model.ScreenTime{
+				sample(0, "kitty", "vim", 1000),
+				sample(time.Second, "kitty", "htop", 1000),
+			},
+			sleeps: []int{1000, 1000},
+		},
+		{
+			name: "app change starts a session",
+			samples: []model.ScreenTime{
+				sample(0, "kitty", "vim", 1000),
+				sample(time.Second, "firefox", "vim", 1000),
+			},
+			sleeps: []int{1000, 1000},
+		},
+		{
+			name: "short samples within max_gap",
+			samples: []model.ScreenTime{
+				sample(0, "kitty", "vim", 500),
+				sample(time.Second, "kitty", "vim", 500),
+			},
+			sleeps: []int{1000},
+		},
+		{
+			// max_gap отсчитывается от начала предыдущей записи, а не от ее конца
+			name: "gap over max_gap",
+			samples: []model.ScreenTime{
+				sample(0, "kitty", "vim", 1000),
+				sample(2*time.Second, "kitty", "vim", 1000),
+			},
+			sleeps: []int{1000, 1000},
+		},
+		{
+			name: "another host",
+			samples: []model.ScreenTime{
+				sample(0, "kitty", "vim", 1000),
+				{Date: testDay.Add(time.Second), AppID: "kitty", Title: "vim", Sleep: 1000, Host: "laptop"},
+			},
+			sleeps: []int{1000, 1000},
+		},
+		{
+			name: "midnight splits a session",
+			samples: []model.ScreenTime{
+				sample(14*time.Hour-time.Second, "kitty", "vim", 1000),
+				sample(14*time.Hour, "kitty", "vim", 1000),
+			},
+			sleeps: []int{1000, 1000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.NewMemoryStore("desktop")
+			if err := store.BulkInsert(tt.samples); err != nil {
+				t.Fatalf("BulkInsert: %v", err)
+			}
+
+			am := aggregatemanager.NewAggragetManager(store, time.Minute, testMaxGap)
+			result, err := am.AggregatePending()
+			if err != nil {
+				t.Fatalf("AggregatePending: %v", err)
+			}
+			if result.Samples != len(tt.samples) || result.Sessions != len(tt.sleeps) || result.Pending != 0 {
+				t.Fatalf("result = %+v, want %d samples in %d sessions", result, len(tt.samples), len(tt.sleeps))
+			}
+
+			state, err := store.State()
+			if err != nil {
+				t.Fatalf("State: %v", err)
+			}
+			if state.Pending != 0 || state.Samples != len(tt.samples) || state.Sessions != len(tt.sleeps) {
+				t.Errorf("state = %+v", state)
+			}
+
+			// записей не осталось, ForEach возвращает только сессии
+			var sleeps []int
+			for _, st := range usage(t, store, testDay, testDay.Add(24*time.Hour), db.UsageFilter{}) {
+				sleeps = append(sleeps, st.Sleep)
+			}
+			if !slices.Equal(sleeps, tt.sleeps) {
+				t.Errorf("sessions = %v, want %v", sleeps, tt.sleeps)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreAggregationIsIncremental(t *testing.T) {
+	store := db.NewMemoryStore("desktop")
+	am := aggregatemanager.NewAggragetManager(store, time.Minute, testMaxGap)
+
+	for i := range 3 {
+		if err := store.BulkInsert([]model.ScreenTime{sample(time.Duration(i)*time.Minute, "kitty", "vim", 1000)}); err != nil {
+			t.Fatalf("BulkInsert: %v", err)
+		}
+		if _, err := am.AggregatePending(); err != nil {
+			t.Fatalf("AggregatePending: %v", err)
+		}
+	}
+
+	state, err := store.State()
+	if err != nil {
+		t.Fatalf("State: %v", err)
+	}
+	if state.Batches != 3 || state.Samples != 3 || state.Sessions != 3 || state.Pending != 0 || state.LastID != 3 {
+		t.Errorf("state = %+v", state)
+	}
+}
+
+func TestMemoryStoreForEachClipping(t *testing.T) {
+	store := db.NewMemoryStore("desktop")
+
+	// сессия 10:00-11:00 и несохраненная запись в 12:00
+	session := model.AggregatedScreenTime{
+		StartedAt: testDay,
+		EndedAt:   testDay.Add(time.Hour),
+		AppID:     "kitty",
+		Title:     "vim",
+		Sleep:     int(time.Hour / time.Millisecond),
+	}
+	if err := store.CommitBatch([]model.AggregatedScreenTime{session}, 0, 0); err != nil {
+		t.Fatalf("CommitBatch: %v", err)
+	}
+	if err := store.BulkInsert([]model.ScreenTime{sample(2*time.Hour, "firefox", "docs", 1000)}); err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		from   time.Duration
+		to     time.Duration
+		filter db.UsageFilter
+		want   []model.ScreenTime
+	}{
+		{
+			name: "whole day",
+			from: -10 * time.Hour,
+			to:   14 * time.Hour,
+			want: []model.ScreenTime{
+				{AppID: "firefox", Title: "docs", Sleep: 1000, Host: "desktop"},
+				{AppID: "kitty", Title: "vim", Sleep: 3600000, Host: "desktop"},
+			},
+		},
+		{
+			name: "second half of the session",
+			from: 30 * time.Minute,
+			to:   90 * time.Minute,
+			want: []model.ScreenTime{{AppID: "kitty", Title: "vim", Sleep: 1800000, Host: "desktop"}},
+		},
+		{
+			name: "inside the session",
+			from: 15 * time.Minute,
+			to:   30 * time.Minute,
+			want: []model.ScreenTime{{AppID: "kitty", Title: "vim", Sleep: 900000, Host: "desktop"}},
+		},
+		{
+			name: "period ends when the session starts",
+			from: -time.Hour,
+			to:   0,
+		},
+		{
+			name: "sample on the period boundary",
+			from: time.Hour,
+			to:   2 * time.Hour,
+			want: []model.ScreenTime{{AppID: "firefox", Title: "docs", Sleep: 1000, Host: "desktop"}},
+		},
+		{
+			name:   "app filter",
+			from:   -10 * time.Hour,
+			to:     14 * time.Hour,
+			filter: db.UsageFilter{AppID: "firefox"},
+			want:   []model.ScreenTime{{AppID: "firefox", Title: "docs", Sleep: 1000, Host: "desktop"}},
+		},
+		{
+			name:   "host filter",
+			from:   -10 * time.Hour,
+			to:     14 * time.Hour,
+			filter: db.UsageFilter{Host: "laptop"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := usage(t, store, testDay.Add(tt.from), testDay.Add(tt.to), tt.filter)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ForEach = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
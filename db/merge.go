@@ -0,0 +1,208 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// MergeResult - итог слияния базы другой машины
+type MergeResult struct {
+	// ImportResult - сессии и необработанные записи, добавленные как сессии
+	ImportResult
+	// Summaries - добавленные или обновленные строки daily_summary
+	Summaries int
+	// SkippedSummaries - суммы за дни, по которым у машины уже есть сессии
+	SkippedSummaries int
+}
+
+// MergeDB - перенос данных из базы другой машины
+type MergeDB struct {
+	conn *DBConnection
+}
+
+func NewMergeDB(conn *DBConnection) *MergeDB {
+	return &MergeDB{conn: conn}
+}
+
+// Необработанные записи переносятся как сессии длиной sleep
+const mergeSessionsQuery = `
+	SELECT started_at, ended_at, sleep, 0 AS sample, host, app_id, title, source FROM aggregated_screen_time
+	UNION ALL
+	SELECT date, date, sleep, 1, host, app_id, title, '' FROM screen_time
+	ORDER BY 1`
+
+// Сумма за день не добавляется, если у машины за этот день уже есть
+// сессии, иначе время посчиталось бы дважды. Повторное слияние не
+// увеличивает суммы.
+const mergeSummaryQuery = `
+	INSERT INTO daily_summary(day, host, app_id, title, title_hash, title_key, sleep, sessions)
+	SELECT ?, ?, ?, ?, ?, ?, ?, ?
+	WHERE NOT EXISTS (
+		SELECT 1 FROM aggregated_screen_time WHERE host = ? AND started_at >= ? AND started_at < ?
+	)
+	ON CONFLICT(day, host, app_id, title_key) DO UPDATE SET
+		sleep = MAX(sleep, excluded.sleep),
+		sessions = MAX(sessions, excluded.sessions)`
+
+// HasHostColumn сообщает, хранит ли база имя машины (базы до версии 8 схемы
+// его не хранят, при обновлении записи помечаются текущим host)
+func (dbc *DBConnection) HasHostColumn() (bool, error) {
+	return hasColumn(dbc.db, "aggregated_screen_time", "host")
+}
+
+// Merge добавляет сессии, необработанные записи и суммы по дням из other
+// в одной транзакции. Время, уже записанное для той же машины, вычитается
+// так же, как при импорте, поэтому повторное слияние ничего не добавляет.
+// Непустой host заменяет имя машины у всех перенесенных записей. С dryRun
+// транзакция откатывается.
+func (mdb *MergeDB) Merge(other *DBConnection, host string, dryRun bool) (MergeResult, error) {
+	fn := "MergeDB:Merge"
+	var result MergeResult
+
+	tx, err := mdb.conn.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	if result.ImportResult, err = mdb.mergeSessions(tx, other, host); err != nil {
+		return result, err
+	}
+
+	if err := mdb.mergeSummaries(tx, other, host, &result); err != nil {
+		return result, err
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	return result, tx.Commit()
+}
+
+func (mdb *MergeDB) mergeSessions(tx *sql.Tx, other *DBConnection, host string) (ImportResult, error) {
+	fn := "MergeDB:mergeSessions"
+
+	si, err := newSessionImporter(mdb.conn, tx)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer si.close()
+
+	rows, err := other.db.Query(mergeSessionsQuery)
+	if err != nil {
+		return si.result, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for rows.Next() {
+		var ast model.AggregatedScreenTime
+		var sample bool
+		var stored string
+
+		if err := rows.Scan(&ast.StartedAt, &ast.EndedAt, &ast.Sleep, &sample, &ast.Host, &ast.AppID, &stored, &ast.Source); err != nil {
+			return si.result, err
+		}
+
+		ast.StartedAt, ast.EndedAt = ast.StartedAt.Local(), ast.EndedAt.Local()
+		if sample {
+			ast.EndedAt = ast.StartedAt.Add(time.Duration(ast.Sleep) * time.Millisecond)
+		}
+
+		if ast.Title, err = other.openTitle(stored); err != nil {
+			return si.result, err
+		}
+
+		if host != "" {
+			ast.Host = host
+		}
+
+		if err := si.add(ast); err != nil {
+			return si.result, err
+		}
+	}
+
+	return si.result, rows.Err()
+}
+
+func (mdb *MergeDB) mergeSummaries(tx *sql.Tx, other *DBConnection, host string, result *MergeResult) error {
+	fn := "MergeDB:mergeSummaries"
+
+	rows, err := other.db.Query("SELECT day, host, app_id, title, sleep, sessions FROM daily_summary ORDER BY day")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for rows.Next() {
+		var du model.DailyUsage
+		var stored string
+
+		if err := rows.Scan(&du.Day, &du.Host, &du.AppID, &stored, &du.Sleep, &du.Sessions); err != nil {
+			return err
+		}
+
+		if host != "" {
+			du.Host = host
+		}
+
+		merged, err := mdb.mergeSummary(tx, other, du, stored)
+		if err != nil {
+			return err
+		}
+
+		if merged {
+			result.Summaries++
+		} else {
+			result.SkippedSummaries++
+		}
+	}
+
+	return rows.Err()
+}
+
+func (mdb *MergeDB) mergeSummary(tx *sql.Tx, other *DBConnection, du model.DailyUsage, stored string) (bool, error) {
+	day, err := time.ParseInLocation(dayFormat, du.Day, time.Local)
+	if err != nil {
+		return false, err
+	}
+
+	if du.Title, err = other.openTitle(stored); err != nil {
+		return false, err
+	}
+
+	title, titleHash, err := mdb.conn.sealTitle(du.Title)
+	if err != nil {
+		return false, err
+	}
+
+	titleKey := titleHash
+	if titleKey == "" {
+		titleKey = title
+	}
+
+	n, err := execCount(tx, mergeSummaryQuery,
+		du.Day, du.Host, du.AppID, title, titleHash, titleKey, du.Sleep, du.Sessions,
+		du.Host, dbTime(day), dbTime(day.AddDate(0, 0, 1)),
+	)
+
+	return n > 0, err
+}
@@ -0,0 +1,587 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// migration - шаг изменения схемы. Шаги применяются по порядку, каждый в
+// своей транзакции; перед destructive шагом делается резервная копия базы.
+type migration struct {
+	version     int
+	name        string
+	destructive bool
+	up          func(tx *sql.Tx, env migrationEnv) error
+}
+
+// migrationEnv - данные подключения, которые нужны миграциям
+type migrationEnv struct {
+	// host - имя этой машины для записей, сделанных до появления колонки host
+	host string
+}
+
+// MigrationInfo описывает миграцию для вывода пользователю
+type MigrationInfo struct {
+	Version     int
+	Name        string
+	Destructive bool
+}
+
+// migrations - все изменения схемы. Существующие шаги не меняются,
+// новые добавляются в конец со следующим номером версии.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create screen_time and aggregated_screen_time",
+		up:      migrateCreateTables,
+	},
+	{
+		version: 2,
+		name:    "add title_hash columns",
+		up:      migrateAddTitleHash,
+	},
+	{
+		version: 3,
+		name:    "add date and app_id indexes",
+		up:      migrateAddIndexes,
+	},
+	{
+		version: 4,
+		name:    "add aggregator_state",
+		up:      migrateAddAggregatorState,
+	},
+	{
+		version:     5,
+		name:        "add started_at and ended_at to aggregated_screen_time",
+		destructive: true,
+		up:          migrateAddSessionBounds,
+	},
+	{
+		version: 6,
+		name:    "add daily_summary",
+		up:      migrateAddDailySummary,
+	},
+	{
+		version: 7,
+		name:    "add source to aggregated_screen_time",
+		up:      migrateAddSource,
+	},
+	{
+		version:     8,
+		name:        "add host to samples, sessions and daily summaries",
+		destructive: true,
+		up:          migrateAddHost,
+	},
+	{
+		version:     9,
+		name:        "store timestamps in UTC",
+		destructive: true,
+		up:          migrateUTCTimestamps,
+	},
+}
+
+// querier - общее для *sql.DB и *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func migrateCreateTables(tx *sql.Tx, _ migrationEnv) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS screen_time (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TIMESTAMP NOT NULL,
+		app_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		sleep INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS aggregated_screen_time (
+		date TIMESTAMP NOT NULL,
+		app_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		sleep INTEGER NOT NULL
+	);
+	`)
+	return err
+}
+
+// migrateAddTitleHash - ключевой хэш заголовка для группировки зашифрованных
+// заголовков. Колонка могла быть добавлена версией без миграций.
+func migrateAddTitleHash(tx *sql.Tx, _ migrationEnv) error {
+	for _, table := range []string{"screen_time", "aggregated_screen_time"} {
+		if err := addColumnIfMissing(tx, table, "title_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateAddIndexes - отчеты выбирают строки по диапазону дат,
+// детализация - по приложению внутри диапазона
+func migrateAddIndexes(tx *sql.Tx, _ migrationEnv) error {
+	_, err := tx.Exec(`
+	CREATE INDEX IF NOT EXISTS screen_time_date ON screen_time(date);
+	CREATE INDEX IF NOT EXISTS screen_time_app_id_date ON screen_time(app_id, date);
+	CREATE INDEX IF NOT EXISTS aggregated_screen_time_date ON aggregated_screen_time(date);
+	CREATE INDEX IF NOT EXISTS aggregated_screen_time_app_id_date ON aggregated_screen_time(app_id, date);
+	`)
+	return err
+}
+
+// migrateAddAggregatorState - прогресс агрегации, одна строка с id = 1
+func migrateAddAggregatorState(tx *sql.Tx, _ migrationEnv) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS aggregator_state (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		last_id INTEGER NOT NULL DEFAULT 0,
+		batches INTEGER NOT NULL DEFAULT 0,
+		samples INTEGER NOT NULL DEFAULT 0,
+		sessions INTEGER NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP
+	);
+
+	INSERT OR IGNORE INTO aggregator_state(id) VALUES(1);
+	`)
+	return err
+}
+
+// migrateAddSessionBounds - начало и конец сессии. Раньше хранилось только
+// время последней записи (date), поэтому для старых строк конец = date,
+// начало = date - sleep.
+func migrateAddSessionBounds(tx *sql.Tx, _ migrationEnv) error {
+	for _, column := range []string{"started_at", "ended_at"} {
+		if err := addColumnIfMissing(tx, "aggregated_screen_time", column, "TIMESTAMP"); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query("SELECT rowid, date, sleep FROM aggregated_screen_time WHERE started_at IS NULL")
+	if err != nil {
+		return err
+	}
+
+	type session struct {
+		rowID int64
+		date  time.Time
+		sleep int
+	}
+
+	var sessions []session
+	for rows.Next() {
+		var s session
+		if err := rows.Scan(&s.rowID, &s.date, &s.sleep); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("UPDATE aggregated_screen_time SET started_at = ?, ended_at = ? WHERE rowid = ?")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			log.Println("db:migrateAddSessionBounds", err)
+		}
+	}()
+
+	for _, s := range sessions {
+		startedAt := s.date.Add(-time.Duration(s.sleep) * time.Millisecond)
+		if _, err := stmt.Exec(startedAt, s.date, s.rowID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+	DROP INDEX IF EXISTS aggregated_screen_time_date;
+	DROP INDEX IF EXISTS aggregated_screen_time_app_id_date;
+	CREATE INDEX IF NOT EXISTS aggregated_screen_time_started_at ON aggregated_screen_time(started_at);
+	CREATE INDEX IF NOT EXISTS aggregated_screen_time_app_id_started_at ON aggregated_screen_time(app_id, started_at);
+	`)
+	return err
+}
+
+// migrateAddDailySummary - суммы по дням для сессий старше срока хранения.
+// day - дата начала сессии в часовом поясе записи (YYYY-MM-DD), title_key -
+// ключ группировки: title_hash для зашифрованных заголовков, иначе title.
+func migrateAddDailySummary(tx *sql.Tx, _ migrationEnv) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS daily_summary (
+		day TEXT NOT NULL,
+		app_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		title_hash TEXT NOT NULL DEFAULT '',
+		title_key TEXT NOT NULL,
+		sleep INTEGER NOT NULL,
+		sessions INTEGER NOT NULL,
+		PRIMARY KEY (day, app_id, title_key)
+	);
+	`)
+	return err
+}
+
+// migrateAddSource - откуда получена сессия: пусто - записана демоном,
+// иначе имя импортированного трекера (activitywatch, timewarrior)
+func migrateAddSource(tx *sql.Tx, _ migrationEnv) error {
+	return addColumnIfMissing(tx, "aggregated_screen_time", "source", "TEXT NOT NULL DEFAULT ''")
+}
+
+// migrateAddHost - машина, на которой сделана запись, для баз, собранных с
+// нескольких машин. Все прежние записи сделаны на этой машине. Первичный
+// ключ daily_summary меняется, поэтому таблица пересоздается.
+func migrateAddHost(tx *sql.Tx, env migrationEnv) error {
+	for _, table := range []string{"screen_time", "aggregated_screen_time"} {
+		if err := addColumnIfMissing(tx, table, "host", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE "+table+" SET host = ? WHERE host = ''", env.host); err != nil { // #nosec G202 -- fixed table names
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`
+	CREATE TABLE daily_summary_new (
+		day TEXT NOT NULL,
+		host TEXT NOT NULL,
+		app_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		title_hash TEXT NOT NULL DEFAULT '',
+		title_key TEXT NOT NULL,
+		sleep INTEGER NOT NULL,
+		sessions INTEGER NOT NULL,
+		PRIMARY KEY (day, host, app_id, title_key)
+	);
+
+	INSERT INTO daily_summary_new(day, host, app_id, title, title_hash, title_key, sleep, sessions)
+	SELECT day, ?, app_id, title, title_hash, title_key, sleep, sessions FROM daily_summary;
+
+	DROP TABLE daily_summary;
+	ALTER TABLE daily_summary_new RENAME TO daily_summary;
+
+	CREATE INDEX IF NOT EXISTS aggregated_screen_time_host_started_at ON aggregated_screen_time(host, started_at);
+	`, env.host)
+	return err
+}
+
+// migrateUTCTimestamps - раньше время записывалось как time.Time.String()
+// в часовом поясе записи, такие строки неверно сравниваются при смене пояса
+// и перехода на летнее время. Все значения переводятся в timeFormat.
+func migrateUTCTimestamps(tx *sql.Tx, _ migrationEnv) error {
+	columns := []struct{ table, column string }{
+		{"screen_time", "date"},
+		{"aggregated_screen_time", "date"},
+		{"aggregated_screen_time", "started_at"},
+		{"aggregated_screen_time", "ended_at"},
+		{"aggregator_state", "updated_at"},
+		{"schema_version", "applied_at"},
+	}
+
+	for _, c := range columns {
+		if err := rewriteTimes(tx, c.table, c.column); err != nil {
+			return fmt.Errorf("%s.%s: %w", c.table, c.column, err)
+		}
+	}
+
+	return nil
+}
+
+func rewriteTimes(tx *sql.Tx, table, column string) error {
+	fn := "db:rewriteTimes"
+
+	// #nosec G202 -- fixed identifiers
+	rows, err := tx.Query("SELECT rowid, CAST(" + column + " AS TEXT) FROM " + table + " WHERE " + column + " IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	type value struct {
+		rowID  int64
+		stored string
+	}
+
+	var values []value
+	for rows.Next() {
+		var v value
+		if err := rows.Scan(&v.rowID, &v.stored); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		values = append(values, v)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("UPDATE " + table + " SET " + column + " = ? WHERE rowid = ?") // #nosec G202 -- fixed identifiers
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for _, v := range values {
+		t, err := parseStoredTime(v.stored)
+		if err != nil {
+			return err
+		}
+
+		if _, err := stmt.Exec(dbTime(t), v.rowID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addColumnIfMissing добавляет колонку в таблицу, созданную старой версией
+func addColumnIfMissing(q querier, table, column, definition string) error {
+	exists, err := hasColumn(q, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = q.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition) // #nosec G202 -- fixed identifiers
+	return err
+}
+
+func hasColumn(q querier, table, column string) (bool, error) {
+	fn := "db:hasColumn"
+
+	rows, err := q.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
+// SchemaVersion возвращает версию схемы базы, 0 - база без schema_version
+func (dbc *DBConnection) SchemaVersion() (int, error) {
+	return schemaVersion(dbc.db)
+}
+
+func schemaVersion(q querier) (int, error) {
+	var tables int
+	err := q.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&tables)
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+
+	var version int
+	err = q.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+
+	return version, err
+}
+
+// LatestSchemaVersion - версия схемы, которую ожидает эта сборка
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// PendingMigrations возвращает миграции, еще не примененные к базе
+func (dbc *DBConnection) PendingMigrations() ([]MigrationInfo, error) {
+	current, err := dbc.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if current > LatestSchemaVersion() {
+		return nil, fmt.Errorf(
+			"database schema version %d is newer than this build supports (%d), please upgrade niri-screen-time",
+			current, LatestSchemaVersion(),
+		)
+	}
+
+	pending := []MigrationInfo{}
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, MigrationInfo{
+				Version:     m.version,
+				Name:        m.name,
+				Destructive: m.destructive,
+			})
+		}
+	}
+
+	return pending, nil
+}
+
+// Migrate применяет недостающие миграции и возвращает примененные
+func (dbc *DBConnection) Migrate() ([]MigrationInfo, error) {
+	dbc.mutex.Lock()
+	defer dbc.mutex.Unlock()
+
+	if _, err := dbc.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return nil, err
+	}
+
+	current, err := dbc.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dbc.PendingMigrations(); err != nil {
+		return nil, err
+	}
+
+	// в новой базе и во временной копии базы только для чтения нечего сохранять
+	hasData, err := hasTable(dbc.db, "screen_time")
+	if err != nil {
+		return nil, err
+	}
+	needBackup := hasData && dbc.tmpDir == ""
+
+	applied := []MigrationInfo{}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		// одной копии до первой разрушающей миграции достаточно: она
+		// сохраняет данные и для всех следующих
+		if m.destructive && needBackup {
+			backup, err := dbc.backupBeforeMigration(m.version)
+			if err != nil {
+				return applied, fmt.Errorf("backup before migration %d: %w", m.version, err)
+			}
+			log.Printf("db: backup before migrations from version %d saved to %s", m.version, backup)
+			needBackup = false
+		}
+
+		if err := dbc.applyMigration(m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+
+		applied = append(applied, MigrationInfo{
+			Version:     m.version,
+			Name:        m.name,
+			Destructive: m.destructive,
+		})
+	}
+
+	return applied, nil
+}
+
+func (dbc *DBConnection) applyMigration(m migration) error {
+	fn := "DBConnection:applyMigration"
+
+	tx, err := dbc.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	if err := m.up(tx, migrationEnv{host: dbc.Host()}); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO schema_version(version, name, applied_at) VALUES(?, ?, ?)",
+		m.version, m.name, dbTime(time.Now()),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// backupBeforeMigration сохраняет копию базы рядом с ней
+func (dbc *DBConnection) backupBeforeMigration(version int) (string, error) {
+	path := fmt.Sprintf("%s.pre-v%d-%s.bak", dbc.path, version, time.Now().Format("20060102-150405"))
+
+	if err := dbc.VacuumInto(path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// VacuumInto записывает согласованную копию базы в path (файл не должен существовать)
+func (dbc *DBConnection) VacuumInto(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("file already exists: %s", path)
+	}
+
+	if err := ensureDir(path); err != nil {
+		return err
+	}
+
+	_, err := dbc.db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// migrateReadOnlyCopy переносит базу, открытую только для чтения, во временную
+// копию и обновляет схему копии. Исходный файл не изменяется.
+func (dbc *DBConnection) migrateReadOnlyCopy() error {
+	tmpDir, err := os.MkdirTemp("", "niri-screen-time-")
+	if err != nil {
+		return err
+	}
+	dbc.tmpDir = tmpDir // удаляется в Close
+
+	tmpPath := filepath.Join(tmpDir, strings.TrimSuffix(filepath.Base(dbc.path), filepath.Ext(dbc.path))+".db")
+
+	// исходный файл открыт с mode=ro, query_only снимается только для VACUUM INTO
+	if _, err := dbc.db.Exec("PRAGMA query_only = 0; VACUUM INTO ?", tmpPath); err != nil {
+		return fmt.Errorf("failed to copy read-only database: %w", err)
+	}
+
+	copyDB, err := openSQLite(fmt.Sprintf("file:%s", tmpPath))
+	if err != nil {
+		return err
+	}
+
+	if err := dbc.db.Close(); err != nil {
+		log.Println("DBConnection:migrateReadOnlyCopy", err)
+	}
+
+	dbc.db = copyDB
+
+	if _, err := dbc.Migrate(); err != nil {
+		return err
+	}
+
+	_, err = dbc.db.Exec("PRAGMA query_only = 1")
+	return err
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// dayFormat - формат колонки daily_summary.day
+const dayFormat = "2006-01-02"
+
+// PruneResult - что удалила или свернула очистка
+type PruneResult struct {
+	// RolledUp - сессии, свернутые в суммы по дням
+	RolledUp int
+	// SummaryRows - добавленные или обновленные строки daily_summary
+	SummaryRows int
+	// Sessions, Samples, Summaries - строки, удаленные по горизонту хранения
+	Sessions  int
+	Samples   int
+	Summaries int
+}
+
+// RetentionDB - сворачивание старых сессий в суммы по дням и удаление
+// данных старше горизонта хранения
+type RetentionDB struct {
+	conn *DBConnection
+}
+
+func NewRetentionDB(conn *DBConnection) *RetentionDB {
+	return &RetentionDB{conn: conn}
+}
+
+// Сессия не переходит через полночь, поэтому день сессии - дата ее начала
+// в текущем часовом поясе. День считается в Go (см. dailyTotals).
+const rollupSessionsQuery = `
+	SELECT started_at, host, app_id, title, title_hash, sleep, 1 FROM aggregated_screen_time
+	WHERE started_at < ?`
+
+// upsertSummaryQuery добавляет сумму за день к уже сохраненной
+const upsertSummaryQuery = `
+	INSERT INTO daily_summary(day, host, app_id, title, title_hash, title_key, sleep, sessions)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(day, host, app_id, title_key) DO UPDATE SET
+		sleep = sleep + excluded.sleep,
+		sessions = sessions + excluded.sessions`
+
+// Prune сворачивает сессии, начатые до rollupBefore, в daily_summary и
+// удаляет все данные до deleteBefore. Нулевое время отключает шаг. Все
+// выполняется в одной транзакции, с dryRun она откатывается, а результат
+// показывает, что было бы сделано.
+func (rdb *RetentionDB) Prune(rollupBefore, deleteBefore time.Time, dryRun bool) (PruneResult, error) {
+	var result PruneResult
+
+	err := rdb.conn.write(func() error {
+		var err error
+		result, err = rdb.prune(rollupBefore, deleteBefore, dryRun)
+		return err
+	})
+
+	return result, err
+}
+
+func (rdb *RetentionDB) prune(rollupBefore, deleteBefore time.Time, dryRun bool) (PruneResult, error) {
+	fn := "RetentionDB:prune"
+	var result PruneResult
+
+	tx, err := rdb.conn.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	if !rollupBefore.IsZero() {
+		if result.SummaryRows, err = rollup(tx, rollupBefore); err != nil {
+			return result, err
+		}
+
+		if result.RolledUp, err = execCount(tx, "DELETE FROM aggregated_screen_time WHERE started_at < ?", dbTime(rollupBefore)); err != nil {
+			return result, err
+		}
+	}
+
+	if !deleteBefore.IsZero() {
+		if result.Sessions, err = execCount(tx, "DELETE FROM aggregated_screen_time WHERE started_at < ?", dbTime(deleteBefore)); err != nil {
+			return result, err
+		}
+
+		if result.Samples, err = execCount(tx, "DELETE FROM screen_time WHERE date < ?", dbTime(deleteBefore)); err != nil {
+			return result, err
+		}
+
+		result.Summaries, err = execCount(tx, "DELETE FROM daily_summary WHERE day < ?", deleteBefore.Format(dayFormat))
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	return result, tx.Commit()
+}
+
+func execCount(tx *sql.Tx, query string, args ...any) (int, error) {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// rollup добавляет сессии, начатые до before, в daily_summary и
+// возвращает число добавленных или обновленных строк
+func rollup(tx *sql.Tx, before time.Time) (int, error) {
+	fn := "db:rollup"
+
+	rows, err := tx.Query(rollupSessionsQuery, dbTime(before))
+	if err != nil {
+		return 0, err
+	}
+
+	days := dailyTotals{}
+	err = days.scan(rows)
+	if e := rows.Close(); e != nil {
+		log.Println(fn, e)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(upsertSummaryQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for key, du := range days {
+		if _, err := stmt.Exec(du.Day, du.Host, du.AppID, du.Title, key.titleHash, key.titleKey, du.Sleep, du.Sessions); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(days), nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// SampleStore - запись сырых записей, собранных демоном
+type SampleStore interface {
+	BulkInsert(records []model.ScreenTime) error
+}
+
+// SessionStore - чтение сырых записей пакетами и атомарная замена их
+// сессиями (см. AggregatorDB)
+type SessionStore interface {
+	State() (AggregatorState, error)
+	NextBatch(afterID, limit int) ([]model.ScreenTime, error)
+	CommitBatch(sessions []model.AggregatedScreenTime, afterID int, lastID int) error
+}
+
+// UsageStore - суммарное время за период (см. UsageDB.ForEach)
+type UsageStore interface {
+	ForEach(from, to *time.Time, filter UsageFilter, each func(model.ScreenTime) error) error
+}
+
+// Storage - все, что нужно демону и отчетам
+type Storage interface {
+	SampleStore
+	SessionStore
+	UsageStore
+}
+
+var (
+	_ SampleStore  = (*ScreenTimeDB)(nil)
+	_ SessionStore = (*AggregatorDB)(nil)
+	_ UsageStore   = (*UsageDB)(nil)
+	_ Storage      = (*MemoryStore)(nil)
+)
+
+// SQLiteStorage объединяет таблицы одной базы в Storage
+type SQLiteStorage struct {
+	*ScreenTimeDB
+	*AggregatorDB
+	*UsageDB
+}
+
+func NewSQLiteStorage(conn *DBConnection) *SQLiteStorage {
+	return &SQLiteStorage{
+		ScreenTimeDB: NewScreenTimeDB(conn),
+		AggregatorDB: NewAggregatorDB(conn),
+		UsageDB:      NewUsageDB(conn),
+	}
+}
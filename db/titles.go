@@ -0,0 +1,231 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+)
+
+// TitleCipher шифрует заголовки окон при записи в базу (см. пакет titlecrypt)
+type TitleCipher interface {
+	Encrypt(title string) (string, error)
+	Decrypt(stored string) (string, error)
+	Hash(title string) string
+}
+
+const (
+	// encryptedPrefix - признак зашифрованного значения (см. titlecrypt)
+	encryptedPrefix = "enc:v1:"
+	// plainPrefix помечает открытый заголовок, который сам начинается с
+	// encryptedPrefix или plainPrefix, чтобы его не приняли за шифртекст
+	plainPrefix = "enc:plain:"
+	// plaintextTitles - условие на незашифрованные заголовки. GLOB, в
+	// отличие от LIKE, учитывает регистр, поэтому "ENC:V1:..." - открытый
+	// текст.
+	plaintextTitles = "title NOT GLOB '" + encryptedPrefix + "*'"
+)
+
+var ErrNoTitleKey = errors.New("database contains encrypted titles, enable encryption in config.yaml to read them")
+
+// SetTitleCipher включает шифрование заголовков, nil - хранить открытым текстом
+func (dbc *DBConnection) SetTitleCipher(cipher TitleCipher) {
+	dbc.cipher = cipher
+}
+
+// sealTitle возвращает значения колонок title и title_hash
+func (dbc *DBConnection) sealTitle(title string) (stored string, hash string, err error) {
+	if dbc.cipher == nil {
+		if strings.HasPrefix(title, encryptedPrefix) || strings.HasPrefix(title, plainPrefix) {
+			return plainPrefix + title, "", nil
+		}
+		return title, "", nil
+	}
+
+	stored, err = dbc.cipher.Encrypt(title)
+	if err != nil {
+		return "", "", err
+	}
+
+	return stored, dbc.cipher.Hash(title), nil
+}
+
+// openTitle расшифровывает значение колонки title
+func (dbc *DBConnection) openTitle(stored string) (string, error) {
+	if title, ok := strings.CutPrefix(stored, plainPrefix); ok {
+		return title, nil
+	}
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return stored, nil
+	}
+
+	if dbc.cipher == nil {
+		return "", ErrNoTitleKey
+	}
+
+	return dbc.cipher.Decrypt(stored)
+}
+
+// EncryptPlaintextTitles шифрует заголовки, сохраненные до включения шифрования,
+// и возвращает количество измененных строк
+func (dbc *DBConnection) EncryptPlaintextTitles() (int, error) {
+	fn := "DBConnection:EncryptPlaintextTitles"
+	if dbc.cipher == nil {
+		return 0, errors.New("encryption is not enabled")
+	}
+
+	total := 0
+	for _, table := range []string{"screen_time", "aggregated_screen_time"} {
+		n, err := dbc.encryptTable(table)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	n, err := dbc.encryptSummaries()
+	if err != nil {
+		return total, err
+	}
+	total += n
+
+	if total > 0 {
+		// освобожденные страницы могут хранить старые заголовки
+		if err := dbc.Vacuum(); err != nil {
+			log.Println(fn, err)
+		}
+	}
+
+	return total, nil
+}
+
+func (dbc *DBConnection) encryptTable(table string) (int, error) {
+	fn := "DBConnection:encryptTable"
+
+	tx, err := dbc.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	rows, err := tx.Query("SELECT rowid, title FROM " + table + " WHERE " + plaintextTitles) // #nosec G202 -- fixed table names
+	if err != nil {
+		return 0, err
+	}
+
+	type plain struct {
+		rowID int64
+		title string
+	}
+
+	var titles []plain
+	for rows.Next() {
+		var p plain
+		if err := rows.Scan(&p.rowID, &p.title); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		titles = append(titles, p)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare("UPDATE " + table + " SET title = ?, title_hash = ? WHERE rowid = ?") // #nosec G202 -- fixed table names
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for _, p := range titles {
+		title, err := dbc.openTitle(p.title)
+		if err != nil {
+			return 0, err
+		}
+
+		stored, hash, err := dbc.sealTitle(title)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := stmt.Exec(stored, hash, p.rowID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(titles), tx.Commit()
+}
+
+// encryptSummaries шифрует заголовки daily_summary. Ключ группировки
+// меняется на хэш, поэтому строка может слиться с уже зашифрованной.
+func (dbc *DBConnection) encryptSummaries() (int, error) {
+	fn := "DBConnection:encryptSummaries"
+
+	tx, err := dbc.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	rows, err := tx.Query("SELECT rowid, day, host, app_id, title, sleep, sessions FROM daily_summary WHERE " + plaintextTitles)
+	if err != nil {
+		return 0, err
+	}
+
+	type summary struct {
+		rowID    int64
+		day      string
+		host     string
+		appID    string
+		title    string
+		sleep    int
+		sessions int
+	}
+
+	var summaries []summary
+	for rows.Next() {
+		var s summary
+		if err := rows.Scan(&s.rowID, &s.day, &s.host, &s.appID, &s.title, &s.sleep, &s.sessions); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		summaries = append(summaries, s)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, s := range summaries {
+		title, err := dbc.openTitle(s.title)
+		if err != nil {
+			return 0, err
+		}
+
+		stored, hash, err := dbc.sealTitle(title)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := tx.Exec("DELETE FROM daily_summary WHERE rowid = ?", s.rowID); err != nil {
+			return 0, err
+		}
+
+		if _, err := tx.Exec(upsertSummaryQuery, s.day, s.host, s.appID, stored, hash, hash, s.sleep, s.sessions); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(summaries), tx.Commit()
+}
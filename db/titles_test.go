@@ -0,0 +1,84 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// testCipher "шифрует" заголовок, просто дописывая его к encryptedPrefix
+type testCipher struct{}
+
+func (testCipher) Encrypt(title string) (string, error) {
+	return encryptedPrefix + title, nil
+}
+
+func (testCipher) Decrypt(stored string) (string, error) {
+	return strings.TrimPrefix(stored, encryptedPrefix), nil
+}
+
+func (testCipher) Hash(title string) string {
+	return "hash:" + title
+}
+
+func TestEncryptPlaintextTitlesIsCaseSensitive(t *testing.T) {
+	conn := openTestDB(t)
+
+	titles := []string{"docs", "ENC:V1:upper", "Enc:v1:mixed", "enc:v1:lower"}
+	var samples []model.ScreenTime
+	for i, title := range titles {
+		samples = append(samples, model.ScreenTime{
+			Date:  journalStart.Add(time.Duration(i) * time.Second),
+			AppID: "kitty",
+			Title: title,
+			Sleep: 1000,
+		})
+	}
+	if err := NewScreenTimeDB(conn).BulkInsert(samples); err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+
+	conn.SetTitleCipher(testCipher{})
+	n, err := conn.EncryptPlaintextTitles()
+	if err != nil {
+		t.Fatalf("EncryptPlaintextTitles: %v", err)
+	}
+	if n != len(titles) {
+		t.Errorf("EncryptPlaintextTitles() = %d, want %d", n, len(titles))
+	}
+
+	rows, err := conn.db.Query("SELECT title FROM screen_time ORDER BY date")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	var opened []string
+	for rows.Next() {
+		var stored string
+		if err := rows.Scan(&stored); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		if !strings.HasPrefix(stored, encryptedPrefix) {
+			t.Errorf("title %q is not encrypted", stored)
+		}
+		title, err := conn.openTitle(stored)
+		if err != nil {
+			t.Fatalf("openTitle: %v", err)
+		}
+		opened = append(opened, title)
+	}
+	if strings.Join(opened, "|") != strings.Join(titles, "|") {
+		t.Errorf("titles = %q, want %q", opened, titles)
+	}
+
+	if n, err := conn.EncryptPlaintextTitles(); err != nil || n != 0 {
+		t.Errorf("second EncryptPlaintextTitles() = %d, %v, want nothing to encrypt", n, err)
+	}
+}
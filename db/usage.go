@@ -0,0 +1,179 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// UsageDB - суммарное время по приложениям и заголовкам из screen_time
+// и aggregated_screen_time, сгруппированное на стороне SQLite
+type UsageDB struct {
+	conn *DBConnection
+}
+
+func NewUsageDB(conn *DBConnection) *UsageDB {
+	return &UsageDB{conn: conn}
+}
+
+// maxSessionLength - сессия не переходит через полночь (см. aggregatemanager),
+// поэтому сессии на границе периода ищутся только в этом окне
+const maxSessionLength = 25 * time.Hour
+
+// UsageFilter - отбор записей отчета, пустое поле не ограничивает
+type UsageFilter struct {
+	AppID string
+	Host  string
+}
+
+// filterCondition - условие UsageFilter, аргументы: AppID, AppID, Host, Host
+const filterCondition = "(? = '' OR app_id = ?) AND (? = '' OR host = ?)"
+
+// Сессии, целиком попавшие в период, и суммы по дням (см. RetentionDB)
+// суммируются в SQL. Зашифрованные заголовки группируются по title_hash
+// (шифротексты одного заголовка различаются), открытые - по самому заголовку.
+const usageQuery = `
+	SELECT host, app_id, MIN(title), SUM(sleep) FROM (
+		SELECT host, app_id, title, title_hash, sleep FROM screen_time
+		WHERE date BETWEEN ? AND ? AND ` + filterCondition + `
+		UNION ALL
+		SELECT host, app_id, title, title_hash, sleep FROM aggregated_screen_time
+		WHERE started_at >= ? AND started_at <= ? AND ended_at <= ? AND ` + filterCondition + `
+		UNION ALL
+		SELECT host, app_id, title, title_hash, sleep FROM daily_summary
+		WHERE day >= ? AND day <= ? AND ` + filterCondition + `
+	)
+	GROUP BY host, app_id, CASE WHEN title_hash = '' THEN title ELSE title_hash END`
+
+// Сессии, пересекающие начало или конец периода, обрезаются в Go
+const boundaryQuery = `
+	SELECT started_at, ended_at, host, app_id, title, sleep FROM aggregated_screen_time
+	WHERE ` + filterCondition + ` AND (
+		(started_at >= ? AND started_at < ? AND ended_at > ?)
+		OR (started_at >= ? AND started_at <= ? AND ended_at > ?)
+	)`
+
+// ForEach вызывает each для каждой тройки машина/приложение/заголовок за
+// период, Sleep - суммарное время. Сессии на границах периода учитываются
+// только частью, попавшей в период. Строки читаются по одной, поэтому
+// память не зависит от длины периода. Одна тройка может прийти несколько
+// раз (например, открытый и зашифрованный заголовок или сессия на
+// границе), вызывающий суммирует. Свернутые дни учитываются целиком,
+// если начало дня попадает в период.
+func (udb *UsageDB) ForEach(
+	from,
+	to *time.Time,
+	filter UsageFilter,
+	each func(model.ScreenTime) error,
+) error {
+	fn := "UsageDB:ForEach"
+
+	firstDay, lastDay := summaryDays(*from, *to)
+	f := filter.args()
+
+	rows, err := udb.conn.db.Query(usageQuery,
+		dbTime(*from), dbTime(*to), f[0], f[1], f[2], f[3],
+		dbTime(*from), dbTime(*to), dbTime(*to), f[0], f[1], f[2], f[3],
+		firstDay, lastDay, f[0], f[1], f[2], f[3],
+	)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for rows.Next() {
+		var st model.ScreenTime
+		if err := rows.Scan(&st.Host, &st.AppID, &st.Title, &st.Sleep); err != nil {
+			return err
+		}
+		if st.Title, err = udb.conn.openTitle(st.Title); err != nil {
+			return err
+		}
+		if err := each(st); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return udb.forEachBoundary(*from, *to, filter, each)
+}
+
+// args возвращает аргументы filterCondition
+func (f UsageFilter) args() []any {
+	return []any{f.AppID, f.AppID, f.Host, f.Host}
+}
+
+func (udb *UsageDB) forEachBoundary(
+	from,
+	to time.Time,
+	filter UsageFilter,
+	each func(model.ScreenTime) error,
+) error {
+	fn := "UsageDB:forEachBoundary"
+
+	// сессии, начатые до from, и сессии, начатые в периоде, но
+	// закончившиеся после to (без повторов)
+	f := filter.args()
+	rows, err := udb.conn.db.Query(boundaryQuery,
+		f[0], f[1], f[2], f[3],
+		dbTime(from.Add(-maxSessionLength)), dbTime(from), dbTime(from),
+		dbTime(maxTime(from, to.Add(-maxSessionLength))), dbTime(to), dbTime(to),
+	)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for rows.Next() {
+		var ast model.AggregatedScreenTime
+		if err := rows.Scan(&ast.StartedAt, &ast.EndedAt, &ast.Host, &ast.AppID, &ast.Title, &ast.Sleep); err != nil {
+			return err
+		}
+		if ast.Title, err = udb.conn.openTitle(ast.Title); err != nil {
+			return err
+		}
+
+		sleep := ast.ClippedSleep(from, to)
+		if sleep == 0 {
+			continue
+		}
+
+		if err := each(model.ScreenTime{AppID: ast.AppID, Title: ast.Title, Sleep: sleep, Host: ast.Host}); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// summaryDays возвращает первый и последний день daily_summary, начало
+// которых попадает в [from, to]
+func summaryDays(from, to time.Time) (string, string) {
+	first := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	if first.Before(from) {
+		first = first.AddDate(0, 0, 1)
+	}
+
+	return first.Format(dayFormat), to.Format(dayFormat)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
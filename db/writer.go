@@ -0,0 +1,217 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+const (
+	// writeQueueSize - запросы, ожидающие записи. Когда очередь полна,
+	// вызывающий ждет, поэтому память не растет, если база не успевает.
+	writeQueueSize = 64
+	// maxWriteBatch - записи screen_time, объединяемые в одну транзакцию
+	maxWriteBatch = 5000
+)
+
+// errWriterStopped - запись после Close
+var errWriterStopped = errors.New("database writer is stopped")
+
+// writeRequest - вставка записей (samples) или другая запись (job).
+// Результат приходит в done.
+type writeRequest struct {
+	samples []model.ScreenTime
+	job     func() error
+	done    chan error
+}
+
+// writer - единственная горутина записи в базу. Запросы выполняются по
+// очереди, поэтому записи демона не соревнуются за блокировку SQLite, а
+// соседние вставки записей объединяются в одну транзакцию.
+type writer struct {
+	conn    *DBConnection
+	queue   chan writeRequest
+	mutex   sync.RWMutex
+	closed  bool
+	stopped chan struct{}
+}
+
+// StartWriter запускает горутину записи: после этого все записи демона
+// (записи, сессии, очистка, VACUUM) идут через нее. Команды работают в
+// своем процессе и пишут напрямую. Горутина останавливается в Close.
+func (dbc *DBConnection) StartWriter() {
+	w := &writer{
+		conn:    dbc,
+		queue:   make(chan writeRequest, writeQueueSize),
+		stopped: make(chan struct{}),
+	}
+	dbc.writer = w
+
+	go w.run()
+}
+
+// write выполняет job в горутине записи, если она запущена, и ждет результат
+func (dbc *DBConnection) write(job func() error) error {
+	if dbc.writer == nil {
+		return job()
+	}
+
+	return dbc.writer.submit(writeRequest{job: job})
+}
+
+// insertSamples сохраняет записи screen_time, в горутине записи - вместе
+// с другими ожидающими вставками
+func (dbc *DBConnection) insertSamples(records []model.ScreenTime) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if dbc.writer == nil {
+		return dbc.insertSamplesTx(records)
+	}
+
+	return dbc.writer.submit(writeRequest{samples: records})
+}
+
+func (w *writer) submit(req writeRequest) error {
+	req.done = make(chan error, 1)
+
+	w.mutex.RLock()
+	if w.closed {
+		w.mutex.RUnlock()
+		return errWriterStopped
+	}
+	w.queue <- req
+	w.mutex.RUnlock()
+
+	return <-req.done
+}
+
+// stop выполняет оставшиеся запросы и останавливает горутину
+func (w *writer) stop() {
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mutex.Unlock()
+
+	<-w.stopped
+}
+
+func (w *writer) run() {
+	defer close(w.stopped)
+
+	var next *writeRequest
+	for {
+		req := next
+		next = nil
+		if req == nil {
+			r, ok := <-w.queue
+			if !ok {
+				return
+			}
+			req = &r
+		}
+
+		if req.samples == nil {
+			req.done <- req.job()
+			continue
+		}
+
+		batch, following := w.collectSamples(*req)
+		w.writeSamples(batch)
+		next = following
+	}
+}
+
+// collectSamples добавляет к first вставки, уже ждущие в очереди. Первый
+// запрос другого вида возвращается, чтобы выполниться следующим.
+func (w *writer) collectSamples(first writeRequest) ([]writeRequest, *writeRequest) {
+	batch := []writeRequest{first}
+	count := len(first.samples)
+
+	for count < maxWriteBatch {
+		select {
+		case r, ok := <-w.queue:
+			if !ok {
+				return batch, nil
+			}
+			if r.samples == nil {
+				return batch, &r
+			}
+			batch = append(batch, r)
+			count += len(r.samples)
+		default:
+			return batch, nil
+		}
+	}
+
+	return batch, nil
+}
+
+// writeSamples сохраняет пакет в одной транзакции. Если она не удалась,
+// запросы сохраняются по отдельности, чтобы ошибка одного не отменяла
+// остальные.
+func (w *writer) writeSamples(batch []writeRequest) {
+	fn := "writer:writeSamples"
+
+	records := make([]model.ScreenTime, 0, len(batch[0].samples))
+	for _, req := range batch {
+		records = append(records, req.samples...)
+	}
+
+	err := w.conn.insertSamplesTx(records)
+	if err == nil || len(batch) == 1 {
+		for _, req := range batch {
+			req.done <- err
+		}
+		return
+	}
+
+	log.Println(fn, "batch failed, writing requests one by one:", err)
+	for _, req := range batch {
+		req.done <- w.conn.insertSamplesTx(req.samples)
+	}
+}
+
+const insertSampleQuery = "INSERT INTO screen_time(date, app_id, title, title_hash, sleep, host) VALUES(?, ?, ?, ?, ?, ?)"
+
+func (dbc *DBConnection) insertSamplesTx(records []model.ScreenTime) error {
+	fn := "DBConnection:insertSamplesTx"
+
+	tx, err := dbc.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println(fn, err)
+		}
+	}()
+
+	stmt, err := tx.Prepare(insertSampleQuery)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			log.Println(fn, err)
+		}
+	}()
+
+	for _, st := range records {
+		title, titleHash, err := dbc.sealTitle(st.Title)
+		if err != nil {
+			return err
+		}
+
+		if _, err := stmt.Exec(dbTime(st.Date), st.AppID, title, titleHash, st.Sleep, dbc.hostOr(st.Host)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -2,12 +2,13 @@
 package detailsmanager
 
 import (
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/probeldev/niri-screen-time/db"
 	"github.com/probeldev/niri-screen-time/model"
+	"github.com/probeldev/niri-screen-time/subprogrammanager"
+	"github.com/probeldev/niri-screen-time/titlenormalizer"
 )
 
 type ResponseManagerInterface interface {
@@ -28,52 +29,35 @@ func NewDetailsManager(
 }
 
 func (d *detailsManager) GetDetails(
-	dbScreenTime *db.ScreenTimeDB,
-	dbAggregate *db.AggregatedScreenTimeDB,
+	usageDB db.UsageStore,
 	from *time.Time,
 	to *time.Time,
 	appID string,
 	title string,
-	isOnlyText bool,
+	host string,
+	normalizer *titlenormalizer.Normalizer,
 ) error {
 	resp := map[string]model.Report{}
 
-	screenTimeList, err := dbScreenTime.GetByDateRange(
-		from,
-		to,
-	)
+	subProgram, err := subprogrammanager.NewSubProgramManager()
 
 	if err != nil {
 		return err
 	}
 
-	aggregate, err := dbAggregate.GetByDateRange(
-		from,
-		to,
-	)
-	if err != nil {
-		return err
-	}
-
-	for _, a := range aggregate {
-		screenTimeList = append(screenTimeList, model.ScreenTime(a))
-	}
-
-	summary := 0
-
-	for _, st := range screenTimeList {
-		summary += st.Sleep
-
+	err = usageDB.ForEach(from, to, db.UsageFilter{AppID: appID, Host: host}, func(st model.ScreenTime) error {
 		if st.AppID != appID {
-			continue
+			return nil
 		}
 
 		if !strings.Contains(st.Title, title) {
-			continue
+			return nil
 		}
 
-		if isOnlyText {
-			st.Title = d.onlyText(st.Title)
+		st = subProgram.RewriteTitle(st)
+
+		if normalizer != nil {
+			st.Title = normalizer.Normalize(st.Title)
 		}
 
 		if report, ok := resp[st.Title]; ok {
@@ -85,6 +69,11 @@ func (d *detailsManager) GetDetails(
 				TimeMs: st.Sleep,
 			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	responseSlice := []model.Report{}
@@ -96,17 +85,3 @@ func (d *detailsManager) GetDetails(
 
 	return nil
 }
-
-func (d *detailsManager) onlyText(s string) string {
-	for i := range 10 {
-		s = strings.ReplaceAll(s, strconv.Itoa(i), "")
-	}
-
-	s = strings.ReplaceAll(s, "(", "")
-	s = strings.ReplaceAll(s, ")", "")
-	s = strings.ReplaceAll(s, "–", "")
-	s = strings.ReplaceAll(s, "-", "")
-	s = strings.Trim(s, " ")
-
-	return s
-}
@@ -0,0 +1,35 @@
+package exportmanager
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// newCSVWriter writes the header row right away
+func newCSVWriter(out io.Writer, columns []string) (*csvWriter, error) {
+	w := &csvWriter{w: csv.NewWriter(out)}
+	if err := w.w.Write(columns); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *csvWriter) Write(values []any) error {
+	record := make([]string, 0, len(values))
+	for _, v := range values {
+		record = append(record, fmt.Sprint(v))
+	}
+
+	return w.w.Write(record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,152 @@
+// Package exportmanager streams stored sessions and summaries in
+// machine-readable formats (CSV, JSON, NDJSON).
+package exportmanager
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+
+	// LevelSessions - every session with its start and end
+	LevelSessions = "sessions"
+	// LevelDaily - totals per day, application and title
+	LevelDaily = "daily"
+	// LevelApps - totals per application
+	LevelApps = "apps"
+)
+
+// timeFormat - RFC 3339 with milliseconds
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// CheckOptions validates level and format before anything is written
+func CheckOptions(level, format string) error {
+	switch level {
+	case LevelSessions, LevelDaily, LevelApps:
+	default:
+		return fmt.Errorf("unknown level %q (sessions, daily, apps)", level)
+	}
+
+	switch format {
+	case FormatCSV, FormatJSON, FormatNDJSON:
+	default:
+		return fmt.Errorf("unknown format %q (csv, json, ndjson)", format)
+	}
+
+	return nil
+}
+
+// RowWriter writes rows of a fixed set of columns
+type RowWriter interface {
+	Write(values []any) error
+	Close() error
+}
+
+// NewRowWriter creates a writer of format that writes to out
+func NewRowWriter(format string, out io.Writer, columns []string) (RowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(out, columns)
+	case FormatJSON:
+		return newJSONWriter(out, columns, false), nil
+	case FormatNDJSON:
+		return newJSONWriter(out, columns, true), nil
+	}
+
+	return nil, fmt.Errorf("unknown format %q (csv, json, ndjson)", format)
+}
+
+type exportManager struct {
+	exportDB *db.ExportDB
+}
+
+func NewExportManager(exportDB *db.ExportDB) *exportManager {
+	em := exportManager{}
+	em.exportDB = exportDB
+
+	return &em
+}
+
+// Export writes the data of level between from and to
+func (em *exportManager) Export(
+	level string,
+	format string,
+	out io.Writer,
+	from *time.Time,
+	to *time.Time,
+) error {
+	switch level {
+	case LevelSessions:
+		return em.exportSessions(format, out, from, to)
+	case LevelDaily:
+		return em.exportDaily(format, out, from, to)
+	case LevelApps:
+		return em.exportApps(format, out, from, to)
+	}
+
+	return fmt.Errorf("unknown level %q (sessions, daily, apps)", level)
+}
+
+func (em *exportManager) exportSessions(format string, out io.Writer, from, to *time.Time) error {
+	w, err := NewRowWriter(format, out, []string{"started_at", "ended_at", "duration_ms", "host", "app_id", "title", "source"})
+	if err != nil {
+		return err
+	}
+
+	err = em.exportDB.Sessions(from, to, func(ast model.AggregatedScreenTime) error {
+		return w.Write([]any{
+			ast.StartedAt.Local().Format(timeFormat),
+			ast.EndedAt.Local().Format(timeFormat),
+			ast.Sleep,
+			ast.Host,
+			ast.AppID,
+			ast.Title,
+			ast.Source,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+func (em *exportManager) exportDaily(format string, out io.Writer, from, to *time.Time) error {
+	w, err := NewRowWriter(format, out, []string{"day", "host", "app_id", "title", "duration_ms", "sessions"})
+	if err != nil {
+		return err
+	}
+
+	err = em.exportDB.Daily(from, to, func(du model.DailyUsage) error {
+		return w.Write([]any{du.Day, du.Host, du.AppID, du.Title, du.Sleep, du.Sessions})
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+func (em *exportManager) exportApps(format string, out io.Writer, from, to *time.Time) error {
+	w, err := NewRowWriter(format, out, []string{"app_id", "duration_ms", "sessions"})
+	if err != nil {
+		return err
+	}
+
+	err = em.exportDB.Apps(from, to, func(au model.AppUsage) error {
+		return w.Write([]any{au.AppID, au.Sleep, au.Sessions})
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
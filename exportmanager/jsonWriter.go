@@ -0,0 +1,74 @@
+package exportmanager
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// jsonWriter writes every row as an object with keys in column order,
+// either as elements of one array or one object per line (NDJSON).
+// bufio.Writer keeps the first write error and returns it from Flush.
+type jsonWriter struct {
+	w       *bufio.Writer
+	columns []string
+	lines   bool
+	rows    int
+}
+
+func newJSONWriter(out io.Writer, columns []string, lines bool) *jsonWriter {
+	return &jsonWriter{
+		w:       bufio.NewWriter(out),
+		columns: columns,
+		lines:   lines,
+	}
+}
+
+func (w *jsonWriter) Write(values []any) error {
+	switch {
+	case w.lines:
+	case w.rows == 0:
+		_, _ = w.w.WriteString("[\n")
+	default:
+		_, _ = w.w.WriteString(",\n")
+	}
+	w.rows++
+
+	_ = w.w.WriteByte('{')
+	for i, column := range w.columns {
+		if i > 0 {
+			_ = w.w.WriteByte(',')
+		}
+
+		key, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+
+		_, _ = w.w.Write(key)
+		_ = w.w.WriteByte(':')
+		_, _ = w.w.Write(value)
+	}
+	_ = w.w.WriteByte('}')
+
+	if w.lines {
+		return w.w.WriteByte('\n')
+	}
+
+	return nil
+}
+
+func (w *jsonWriter) Close() error {
+	if !w.lines {
+		if w.rows == 0 {
+			_, _ = w.w.WriteString("[")
+		}
+		_, _ = w.w.WriteString("\n]\n")
+	}
+
+	return w.w.Flush()
+}
@@ -0,0 +1,73 @@
+package importmanager
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// awBucketType - buckets of aw-watcher-window, other buckets (afk, web)
+// are skipped
+const awBucketType = "currentwindow"
+
+type awExport struct {
+	Buckets map[string]awBucket `json:"buckets"`
+}
+
+type awBucket struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Events []awEvent `json:"events"`
+}
+
+type awEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Duration - seconds
+	Duration float64 `json:"duration"`
+	Data     struct {
+		App   string `json:"app"`
+		Title string `json:"title"`
+	} `json:"data"`
+}
+
+// parseActivityWatch reads an ActivityWatch export: either all buckets
+// ({"buckets": {...}}) or a single bucket ({"id": ..., "events": [...]})
+func parseActivityWatch(r io.Reader) (ParseResult, error) {
+	var raw struct {
+		awExport
+		awBucket
+	}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return ParseResult{}, err
+	}
+
+	buckets := raw.Buckets
+	if len(buckets) == 0 {
+		buckets = map[string]awBucket{raw.ID: raw.awBucket}
+	}
+
+	result := ParseResult{}
+	for _, bucket := range buckets {
+		if bucket.Type != awBucketType {
+			result.Skipped += len(bucket.Events)
+			continue
+		}
+
+		for _, e := range bucket.Events {
+			duration := time.Duration(e.Duration * float64(time.Second))
+			if duration <= 0 || e.Data.App == "" {
+				result.Skipped++
+				continue
+			}
+
+			result.Events = append(result.Events, Event{
+				Start: e.Timestamp,
+				End:   e.Timestamp.Add(duration),
+				AppID: e.Data.App,
+				Title: e.Data.Title,
+			})
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,136 @@
+// Package importmanager imports history from other trackers
+// (ActivityWatch, Timewarrior) as aggregated sessions.
+package importmanager
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+const (
+	SourceActivityWatch = "activitywatch"
+	SourceTimewarrior   = "timewarrior"
+)
+
+// Event - time span read from another tracker
+type Event struct {
+	Start  time.Time
+	End    time.Time
+	AppID  string
+	Title  string
+	Source string
+}
+
+// ParseResult - events of a file and the number of records that cannot
+// be imported (other bucket types, intervals still running, ...)
+type ParseResult struct {
+	Events  []Event
+	Skipped int
+}
+
+// Filter returns the session to store, ok is false if it must be dropped
+type Filter func(model.ScreenTime) (model.ScreenTime, bool)
+
+// DetectSource guesses the tracker by the file name
+func DetectSource(path string) (string, error) {
+	switch filepath.Ext(path) {
+	case ".json":
+		return SourceActivityWatch, nil
+	case ".data":
+		return SourceTimewarrior, nil
+	}
+
+	return "", fmt.Errorf("%s: cannot detect the format, use -format activitywatch or -format timewarrior", path)
+}
+
+// Parse reads the events of source from r
+func Parse(source string, r io.Reader) (ParseResult, error) {
+	var result ParseResult
+	var err error
+
+	switch source {
+	case SourceActivityWatch:
+		result, err = parseActivityWatch(r)
+	case SourceTimewarrior:
+		result, err = parseTimewarrior(r)
+	default:
+		return result, fmt.Errorf("unknown format %q (activitywatch, timewarrior)", source)
+	}
+
+	for i := range result.Events {
+		result.Events[i].Source = source
+	}
+
+	return result, err
+}
+
+type importManager struct {
+	importDB *db.ImportDB
+	filter   Filter
+}
+
+func NewImportManager(importDB *db.ImportDB, filter Filter) *importManager {
+	im := importManager{}
+	im.importDB = importDB
+	im.filter = filter
+
+	return &im
+}
+
+// Import stores events, filtered like samples of the daemon (privacy
+// rules, normalization), and returns the result and the number of events
+// dropped by the filter. Events are split at local midnight, because a
+// session never spans two days. The whole span of an event is active time.
+func (im *importManager) Import(events []Event, dryRun bool) (db.ImportResult, int, error) {
+	sessions := []model.AggregatedScreenTime{}
+	dropped := 0
+
+	for _, e := range events {
+		st, ok := im.filter(model.ScreenTime{AppID: e.AppID, Title: e.Title})
+		if !ok {
+			dropped++
+			continue
+		}
+
+		for _, span := range splitAtMidnight(e.Start.Local(), e.End.Local()) {
+			sessions = append(sessions, model.AggregatedScreenTime{
+				StartedAt: span[0],
+				EndedAt:   span[1],
+				AppID:     st.AppID,
+				Title:     st.Title,
+				Sleep:     int(span[1].Sub(span[0]).Milliseconds()),
+				Source:    e.Source,
+			})
+		}
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+
+	result, err := im.importDB.Import(sessions, dryRun)
+
+	return result, dropped, err
+}
+
+func splitAtMidnight(start, end time.Time) [][2]time.Time {
+	var spans [][2]time.Time
+
+	for start.Before(end) {
+		midnight := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, start.Location())
+		if !midnight.Before(end) {
+			return append(spans, [2]time.Time{start, end})
+		}
+
+		spans = append(spans, [2]time.Time{start, midnight})
+		start = midnight
+	}
+
+	return spans
+}
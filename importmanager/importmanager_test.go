@@ -0,0 +1,176 @@
+package importmanager
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/db"
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+var importDay = time.Date(2025, time.March, 10, 0, 0, 0, 0, time.UTC)
+
+func keepAll(st model.ScreenTime) (model.ScreenTime, bool) {
+	return st, true
+}
+
+// event - an event of app from start to end after the start of importDay
+func event(app string, start, end time.Duration) Event {
+	return Event{
+		Start:  importDay.Add(start),
+		End:    importDay.Add(end),
+		AppID:  app,
+		Title:  "title",
+		Source: SourceActivityWatch,
+	}
+}
+
+func openImportDB(t *testing.T) *db.DBConnection {
+	t.Helper()
+
+	conn, err := db.NewDBConnection(filepath.Join(t.TempDir(), "db.db"))
+	if err != nil {
+		t.Fatalf("NewDBConnection: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	conn.SetHost("desktop")
+	if err := conn.InitTables(); err != nil {
+		t.Fatalf("InitTables: %v", err)
+	}
+
+	return conn
+}
+
+// storedSleeps returns the time of all stored sessions by their start
+func storedSleeps(t *testing.T, conn *db.DBConnection) []int {
+	t.Helper()
+
+	from, to := importDay.AddDate(0, 0, -1), importDay.AddDate(0, 0, 2)
+	var sleeps []int
+	err := db.NewExportDB(conn, time.Second).Sessions(&from, &to, func(ast model.AggregatedScreenTime) error {
+		sleeps = append(sleeps, ast.Sleep)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Sessions: %v", err)
+	}
+
+	return sleeps
+}
+
+func TestImportStoresDurations(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	hour := int(time.Hour.Milliseconds())
+
+	tests := []struct {
+		name string
+		// before - events imported earlier
+		before      []Event
+		events      []Event
+		wantSleep   int
+		wantTrimmed int
+		wantStored  []int
+	}{
+		{
+			name: "no overlap",
+			events: []Event{
+				event("firefox", 10*time.Hour, 11*time.Hour),
+				event("kitty", 12*time.Hour, 12*time.Hour+10*time.Minute),
+			},
+			wantSleep:  hour + hour/6,
+			wantStored: []int{hour, hour / 6},
+		},
+		{
+			name:   "overlap with an earlier import",
+			before: []Event{event("firefox", 10*time.Hour, 11*time.Hour)},
+			events: []Event{
+				event("kitty", 10*time.Hour+30*time.Minute, 11*time.Hour+30*time.Minute),
+			},
+			wantSleep:   hour / 2,
+			wantTrimmed: 1,
+			wantStored:  []int{hour, hour / 2},
+		},
+		{
+			name: "overlap within one import",
+			events: []Event{
+				event("firefox", 10*time.Hour, 11*time.Hour),
+				event("kitty", 10*time.Hour+45*time.Minute, 12*time.Hour),
+			},
+			wantSleep:   2 * hour,
+			wantTrimmed: 1,
+			wantStored:  []int{hour, hour},
+		},
+		{
+			name:       "split at midnight",
+			events:     []Event{event("mpv", 23*time.Hour+30*time.Minute, 24*time.Hour+30*time.Minute)},
+			wantSleep:  hour,
+			wantStored: []int{hour / 2, hour / 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := openImportDB(t)
+			im := NewImportManager(db.NewImportDB(conn), keepAll)
+
+			if _, _, err := im.Import(tt.before, false); err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+
+			result, dropped, err := im.Import(tt.events, false)
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if dropped != 0 || result.Sleep != tt.wantSleep || result.Trimmed != tt.wantTrimmed {
+				t.Errorf("Import() = %+v, %d dropped, want %d ms and %d trimmed", result, dropped, tt.wantSleep, tt.wantTrimmed)
+			}
+
+			if stored := storedSleeps(t, conn); !slices.Equal(stored, tt.wantStored) {
+				t.Errorf("stored sessions = %v ms, want %v", stored, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestImportActivityWatchBucket(t *testing.T) {
+	bucket := `{
+		"id": "aw-watcher-window_desktop",
+		"type": "currentwindow",
+		"events": [
+			{"timestamp": "2025-03-10T10:00:00Z", "duration": 3600, "data": {"app": "firefox", "title": "docs"}},
+			{"timestamp": "2025-03-10T12:00:00Z", "duration": 600, "data": {"app": "kitty", "title": "vim"}},
+			{"timestamp": "2025-03-10T13:00:00Z", "duration": 0, "data": {"app": "kitty", "title": "vim"}}
+		]
+	}`
+
+	parsed, err := Parse(SourceActivityWatch, strings.NewReader(bucket))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(parsed.Events) != 2 || parsed.Skipped != 1 {
+		t.Fatalf("Parse() = %d events, %d skipped, want 2 and 1", len(parsed.Events), parsed.Skipped)
+	}
+
+	conn := openImportDB(t)
+	result, _, err := NewImportManager(db.NewImportDB(conn), keepAll).Import(parsed.Events, false)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if result.Imported != 2 || result.Sleep != 4200000 {
+		t.Errorf("Import() = %+v, want 2 sessions of 4200000 ms", result)
+	}
+
+	if stored := storedSleeps(t, conn); !slices.Equal(stored, []int{3600000, 600000}) {
+		t.Errorf("stored sessions = %v ms, want [3600000 600000]", stored)
+	}
+}
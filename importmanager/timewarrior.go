@@ -0,0 +1,103 @@
+package importmanager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// timewarriorAppID - Timewarrior tracks tags, not windows: every
+// interval becomes a session of this application titled by its tags
+const timewarriorAppID = "timewarrior"
+
+const timewarriorTimeFormat = "20060102T150405Z"
+
+// parseTimewarrior reads a Timewarrior data file (YYYY-MM.data):
+//
+//	inc 20250101T090000Z - 20250101T103000Z # tag "tag with spaces" # annotation
+//
+// Intervals without an end are still running and are skipped.
+func parseTimewarrior(r io.Reader) (ParseResult, error) {
+	result := ParseResult{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		event, ok, err := parseTimewarriorLine(text)
+		if err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+		if !ok {
+			result.Skipped++
+			continue
+		}
+
+		result.Events = append(result.Events, event)
+	}
+
+	return result, scanner.Err()
+}
+
+func parseTimewarriorLine(line string) (Event, bool, error) {
+	interval, tags, _ := strings.Cut(line, " # ")
+	tags, _, _ = strings.Cut(tags, " # ")
+
+	fields := strings.Fields(interval)
+	if len(fields) == 0 || fields[0] != "inc" {
+		return Event{}, false, fmt.Errorf("unexpected record %q", line)
+	}
+
+	if len(fields) != 4 || fields[2] != "-" {
+		return Event{}, false, nil
+	}
+
+	start, err := time.Parse(timewarriorTimeFormat, fields[1])
+	if err != nil {
+		return Event{}, false, err
+	}
+
+	end, err := time.Parse(timewarriorTimeFormat, fields[3])
+	if err != nil {
+		return Event{}, false, err
+	}
+
+	return Event{
+		Start: start,
+		End:   end,
+		AppID: timewarriorAppID,
+		Title: strings.Join(splitTags(tags), " "),
+	}, true, nil
+}
+
+// splitTags splits space separated tags, "quoted tags" may contain spaces
+func splitTags(s string) []string {
+	var tags []string
+	var tag strings.Builder
+	quoted := false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if tag.Len() > 0 {
+				tags = append(tags, tag.String())
+				tag.Reset()
+			}
+		default:
+			tag.WriteRune(r)
+		}
+	}
+
+	if tag.Len() > 0 {
+		tags = append(tags, tag.String())
+	}
+
+	return tags
+}
@@ -1,36 +1,54 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/probeldev/niri-screen-time/activewindowmanager"
 	"github.com/probeldev/niri-screen-time/activewindowmanager/macos"
 	"github.com/probeldev/niri-screen-time/aggregatemanager"
 	"github.com/probeldev/niri-screen-time/autostartmanager"
+	"github.com/probeldev/niri-screen-time/backupmanager"
 	"github.com/probeldev/niri-screen-time/cache"
+	"github.com/probeldev/niri-screen-time/config"
 	"github.com/probeldev/niri-screen-time/daemon"
 	"github.com/probeldev/niri-screen-time/db"
 	"github.com/probeldev/niri-screen-time/detailsmanager"
 	"github.com/probeldev/niri-screen-time/model"
 	"github.com/probeldev/niri-screen-time/reportmanager"
 	"github.com/probeldev/niri-screen-time/responsemanager"
+	"github.com/probeldev/niri-screen-time/retentionmanager"
+	"github.com/probeldev/niri-screen-time/subprogrammanager"
+	"github.com/probeldev/niri-screen-time/titlecrypt"
+	"github.com/probeldev/niri-screen-time/titlenormalizer"
 )
 
 type Config struct {
 	IsDaemon       bool
 	IsDetails      bool
+	Period         *periodFlags
 	From           *time.Time
 	To             *time.Time
 	AppID          string
 	Title          string
 	Limit          int
-	IsOnlyText     bool
 	IsJSON         bool
 	IsMacOsStartup bool
+	IsReadOnly     bool
+	Host           string
+	PerHost        bool
+	ConfigPath     string
+	Overrides      map[string]string
+	Settings       *config.Config
 }
 
 func main() {
@@ -58,15 +76,34 @@ func GetResponseManager(
 		cfg.From,
 		cfg.To,
 		cfg.Limit,
+		cfg.Settings.Output.TruncateLength,
 	)
 	return responseManager
 }
 
 func run() error {
+	if isCommand(os.Args) {
+		return runCommand(os.Args[1], os.Args[2:])
+	}
+
 	cfg := parseFlags()
 
+	settings, err := config.Load(cfg.ConfigPath, cfg.Overrides)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	cfg.Settings = settings
+
+	// the week start comes from the config, so the period is resolved after it
+	from, to, err := cfg.Period.resolve(time.Now(), settings.Output.FirstWeekday())
+	if err != nil {
+		return err
+	}
+	cfg.From = &from
+	cfg.To = &to
+
 	if cfg.IsDaemon {
-		return runDaemonMode()
+		return runDaemonMode(cfg)
 	}
 
 	if cfg.IsMacOsStartup {
@@ -88,36 +125,35 @@ func run() error {
 }
 
 func parseFlags() *Config {
-	fn := "parseFlags"
 	cfg := &Config{}
 
 	showVersion := false
 
-	var fromStr string
-	var toStr string
-
 	flag.BoolVar(&cfg.IsDaemon, "daemon", false, "Run daemon")
 	flag.BoolVar(&cfg.IsDetails, "details", false, "View details")
-	flag.BoolVar(&cfg.IsOnlyText, "onlytext", false, "Hack for remove counter from title")
 	flag.BoolVar(&cfg.IsJSON, "json", false, "return response with json format")
 	flag.BoolVar(&cfg.IsMacOsStartup, "autostart", false, "manage macos autostart (enable/disable/status)")
-	flag.StringVar(&fromStr, "from", "", "Start date (format: 2006-01-02), defaults to today")
-	flag.StringVar(&toStr, "to", "", "End date (format: 2006-01-02), defaults to today")
+	cfg.Period = addPeriodFlags(flag.CommandLine, "defaults to today")
 	flag.StringVar(&cfg.AppID, "appid", "", "AppId")
 	flag.StringVar(&cfg.Title, "title", "", "Substring to match in titles")
 	flag.IntVar(&cfg.Limit, "limit", 0, "Limit of response line, defaults to unlimited")
 	flag.BoolVar(&showVersion, "version", false, "print version and exit")
+	flag.StringVar(&cfg.ConfigPath, "config", "", "Path to config.yaml, defaults to $XDG_CONFIG_HOME/niri-screen-time/config.yaml")
+	flag.BoolVar(&cfg.IsReadOnly, "readonly", false, "Open the database read-only (reports only)")
+	flag.StringVar(&cfg.Host, "host", "", "Only count time recorded on this machine (see db merge)")
+	flag.BoolVar(&cfg.PerHost, "per-host", false, "Report every application once per machine")
+	flag.Func("tz", "Time zone for dates and day boundaries (e.g. Europe/Berlin), defaults to the local zone", setTimeZone)
+	cfg.Overrides = config.RegisterFlags(flag.CommandLine)
+	flag.Func("db", "Path to the database file (same as -storage-path)", func(value string) error {
+		cfg.Overrides["storage.path"] = value
+		return nil
+	})
+	flag.BoolFunc("onlytext", "Normalize titles in details (deprecated, same as -normalization-at-report)", func(value string) error {
+		cfg.Overrides["normalization.at_report"] = value
+		return nil
+	})
 	flag.Parse()
 
-	from, to, err := parseDates(fromStr, toStr)
-	if err != nil {
-		log.Println(fn, err)
-		os.Exit(0)
-	}
-
-	cfg.From = &from
-	cfg.To = &to
-
 	if showVersion {
 		fmt.Println(version)
 		os.Exit(0)
@@ -126,50 +162,167 @@ func parseFlags() *Config {
 	return cfg
 }
 
-func runDaemonMode() error {
+func runDaemonMode(cfg *Config) error {
 	fn := "runDaemonMode"
-	// Create a database connection
-	conn, err := db.NewDBConnection()
+	settings := cfg.Settings
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if db.IsMemoryPath(settings.Storage.Path) {
+		log.Println(fn, "storing samples in memory, they are lost when the daemon stops")
+		return runDaemon(ctx, cfg, db.NewMemoryStore(settings.Storage.Host), nil, nil)
+	}
+
+	// Only one daemon may write to a database, restore waits for it to stop
+	lock, err := db.LockDatabase(settings.Storage.Path)
+	if errors.Is(err, db.ErrLocked) {
+		return errors.New("another daemon is already running with this database")
+	}
 	if err != nil {
 		log.Panic(fn, err)
 	}
 	defer func() {
-		err = conn.Close()
-		if err != nil {
-			log.Panic(fn, err)
+		if err := lock.Unlock(); err != nil {
+			log.Println(fn, err)
 		}
 	}()
 
-	go func() {
-		err := conn.Vacuum()
-		if err != nil {
-			log.Panic(fn, err)
-		}
-	}()
+	// Create a database connection
+	conn, err := db.NewDBConnection(settings.Storage.Path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(conn)
+
+	if err := configureDB(conn, settings); err != nil {
+		return err
+	}
 
 	if err := conn.InitTables(); err != nil {
-		log.Panic(fn, err)
+		return err
 	}
 
-	screenDB := db.NewScreenTimeDB(conn)
-	aggregateDB := db.NewAggregatedScreenTimeDB(conn)
+	// From now on every write goes through one goroutine
+	conn.StartWriter()
+
+	// Background workers stop with the daemon and are waited for before
+	// the connection is closed
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	background := func(run func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run()
+		}()
+	}
 
-	go func() {
-		am := aggregatemanager.NewAggragetManager(
-			*screenDB,
-			*aggregateDB,
-		)
+	background(func() {
+		if err := conn.Vacuum(); err != nil {
+			log.Println(fn, err)
+		}
+	})
+
+	rm := retentionmanager.NewRetentionManager(
+		*db.NewRetentionDB(conn),
+		settings.Retention,
+	)
+	background(func() { rm.Run(ctx) })
+
+	bm := backupmanager.NewBackupManager(conn, settings.Backup)
+	background(func() { bm.Run(ctx) })
+
+	// Buffered samples are journaled next to the database and survive a crash
+	var journal cache.Journal
+	if j, err := db.OpenSampleJournal(conn); err != nil {
+		log.Println(fn, "the sample journal is disabled:", err)
+	} else {
+		journal = j
+	}
 
-		am.Aggregate()
+	return runDaemon(ctx, cfg, db.NewSQLiteStorage(conn), journal, func(c *config.Config) {
+		rm.SetSettings(c.Retention)
+		bm.SetSettings(c.Backup)
+	})
+}
+
+// runDaemon collects samples into storage and aggregates them until ctx
+// is canceled. journal, if set, backs up the sample buffer on disk.
+// onChange, if set, receives reloaded settings as well.
+func runDaemon(ctx context.Context, cfg *Config, storage db.Storage, journal cache.Journal, onChange func(*config.Config)) error {
+	fn := "runDaemon"
+	settings := cfg.Settings
+
+	// The aggregator finishes its run before runDaemon returns
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	am := aggregatemanager.NewAggragetManager(
+		storage,
+		settings.Aggregation.Interval,
+		settings.Aggregation.MaxGap,
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		am.Aggregate(ctx)
 	}()
 
-	screenTimeCache := cache.NewScreenTimeCache(screenDB, 5*time.Second, 100)
+	screenTimeCache := cache.NewScreenTimeCache(
+		storage,
+		settings.Storage.FlushPeriod,
+		settings.Storage.MaxBuffer,
+	)
+	if journal != nil {
+		screenTimeCache.SetJournal(journal)
+	}
 	screenTimeCache.Start()
 	defer screenTimeCache.Stop()
 
+	wm, err := activewindowmanager.GetActiveWindowManagerByName(settings.Backends.WindowManager)
+	if err != nil {
+		log.Panic(fn, err)
+	}
+
+	store, err := config.NewStore(cfg.ConfigPath, cfg.Overrides, settings)
+	if err != nil {
+		log.Panic(fn, err)
+	}
+
+	store.OnChange(func(c *config.Config) {
+		screenTimeCache.SetLimits(c.Storage.FlushPeriod, c.Storage.MaxBuffer)
+		am.SetSettings(c.Aggregation.Interval, c.Aggregation.MaxGap)
+		if onChange != nil {
+			onChange(c)
+		}
+	})
+
+	if err := store.Watch(); err != nil {
+		log.Println(fn, "config hot-reload is disabled:", err)
+	}
+
+	// Reports apply subprogram rules themselves, the daemon reloads them
+	// to report a broken file as soon as it is saved
+	err = subprogrammanager.Watch(func(spm *subprogrammanager.SubProgramManager) {
+		log.Println("subprograms: reloaded", spm.Rules(), "rules")
+	})
+	if err != nil {
+		log.Println(fn, "subprogram rules hot-reload is disabled:", err)
+	}
+
+	// The deferred Stop flushes the buffer and closes the journal, the
+	// database is closed by the caller
 	log.Println("Starting daemon...")
 
-	daemon.Run(screenTimeCache)
+	daemon.Run(ctx, screenTimeCache, wm, store)
+
+	log.Println("Stopping daemon...")
 
 	return nil
 }
@@ -258,40 +411,86 @@ func manageAutoStart(cfg *Config) error {
 	return nil
 }
 
-func runReportMode(
-	cfg *Config,
-	responseManager reportmanager.ResponseManagerInterface,
-) error {
-	fn := "runReportMode"
-	// Create a database connection
-	conn, err := db.NewDBConnection()
+// openUsageStore opens the storage of report and details modes, an
+// in-memory store starts empty
+func openUsageStore(cfg *Config) (db.UsageStore, func(), error) {
+	if db.IsMemoryPath(cfg.Settings.Storage.Path) {
+		return db.NewMemoryStore(cfg.Settings.Storage.Host), func() {}, nil
+	}
+
+	conn, err := openReportDB(cfg)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
+	}
+
+	return db.NewUsageDB(conn), func() { closeDB(conn) }, nil
+}
+
+// openReportDB opens the database for report and details modes,
+// read-only if requested
+func openReportDB(cfg *Config) (*db.DBConnection, error) {
+	var conn *db.DBConnection
+	var err error
+
+	if cfg.IsReadOnly {
+		conn, err = db.NewReadOnlyDBConnection(cfg.Settings.Storage.Path)
+	} else {
+		conn, err = db.NewDBConnection(cfg.Settings.Storage.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = configureDB(conn, cfg.Settings); err != nil {
+		closeDB(conn)
+		return nil, err
 	}
-	defer func() {
-		err = conn.Close()
-		if err != nil {
-			log.Panic(fn, err)
-		}
-	}()
 
 	if err = conn.InitTables(); err != nil {
-		log.Panic(fn, err)
+		closeDB(conn)
+		return nil, err
 	}
 
-	screenTimeDB := db.NewScreenTimeDB(conn)
+	return conn, nil
+}
+
+// configureDB applies the host name and, if it is configured, title
+// encryption. It runs before InitTables so migrations see both.
+func configureDB(conn *db.DBConnection, settings *config.Config) error {
+	conn.SetHost(settings.Storage.Host)
 
-	aggregateDB := db.NewAggregatedScreenTimeDB(conn)
+	cipher, err := titlecrypt.LoadCipher(settings.Encryption)
+	if err != nil {
+		return err
+	}
+
+	if cipher != nil {
+		conn.SetTitleCipher(cipher)
+	}
+
+	return nil
+}
+
+func runReportMode(
+	cfg *Config,
+	responseManager reportmanager.ResponseManagerInterface,
+) error {
+	usageDB, closeStore, err := openUsageStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeStore()
 
 	report := reportmanager.NewResponseManager(
 		responseManager,
 	)
 
 	return report.GetReport(
-		screenTimeDB,
-		aggregateDB,
+		usageDB,
 		cfg.From,
 		cfg.To,
+		cfg.Host,
+		cfg.PerHost,
 	)
 }
 
@@ -299,70 +498,44 @@ func runDetailsMode(
 	cfg *Config,
 	responseManager detailsmanager.ResponseManagerInterface,
 ) error {
-	fn := "runDetailsMode"
-	// Create a database connection
-	conn, err := db.NewDBConnection()
+	usageDB, closeStore, err := openUsageStore(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func() {
-		err = conn.Close()
-		if err != nil {
-			log.Panic(fn, err)
-		}
-	}()
-
-	if err = conn.InitTables(); err != nil {
-		log.Panic(fn, err)
-	}
-
-	screenTimeDB := db.NewScreenTimeDB(conn)
-
-	aggregateDB := db.NewAggregatedScreenTimeDB(conn)
+	defer closeStore()
 
 	details := detailsmanager.NewDetailsManager(
 		responseManager,
 	)
 
+	var normalizer *titlenormalizer.Normalizer
+	if cfg.Settings.Normalization.AtReport {
+		normalizer, err = titlenormalizer.NewNormalizer(cfg.Settings.Normalization)
+		if err != nil {
+			return err
+		}
+	}
+
 	return details.GetDetails(
-		screenTimeDB,
-		aggregateDB,
+		usageDB,
 		cfg.From,
 		cfg.To,
 		cfg.AppID,
 		cfg.Title,
-		cfg.IsOnlyText,
+		cfg.Host,
+		normalizer,
 	)
 }
 
-func parseDates(
-	fromStr,
-	toStr string,
-) (
-	from time.Time,
-	to time.Time,
-	err error,
-) {
-	now := time.Now()
-	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-	todayEnd := todayStart.Add(24*time.Hour - 1*time.Nanosecond)
-
-	parseDate := func(dateStr string, defaultDate time.Time) (time.Time, error) {
-		if dateStr == "" {
-			return defaultDate, nil
-		}
-		return time.Parse("2006-01-02", dateStr)
-	}
-
-	from, err = parseDate(fromStr, todayStart)
+// setTimeZone replaces the local time zone: dates on the command line,
+// day boundaries and printed times use it
+func setTimeZone(name string) error {
+	loc, err := time.LoadLocation(name)
 	if err != nil {
-		return time.Time{}, time.Time{}, fmt.Errorf("invalid from date: %w", err)
+		return fmt.Errorf("invalid time zone: %w", err)
 	}
 
-	to, err = parseDate(toStr, todayEnd)
-	if err != nil {
-		return time.Time{}, time.Time{}, fmt.Errorf("invalid to date: %w", err)
-	}
+	time.Local = loc
 
-	return from, to, nil
+	return nil
 }
@@ -4,22 +4,37 @@ import (
 	"time"
 )
 
+// AggregatedScreenTime - session of consecutive samples of one window.
+// A sample taken at Date covers the following Sleep milliseconds, so the
+// session lasts from the first sample until the end of the last one.
 type AggregatedScreenTime struct {
-	ID    int
-	Date  time.Time
-	AppID string
-	Title string
-	Sleep int
+	ID        int
+	StartedAt time.Time
+	EndedAt   time.Time
+	AppID     string
+	Title     string
+	Sleep     int
+	// Source - tracker the session was imported from, empty if it was
+	// recorded by the daemon
+	Source string
+	// Host - machine the session was recorded on
+	Host string
+	// lastSample - start of the last sample of a session being built,
+	// max_gap is measured from it
+	lastSample time.Time
 }
 
 func NewAggregatedScreenTimeFromScreenTime(
 	screenTime ScreenTime,
 ) AggregatedScreenTime {
 	asc := AggregatedScreenTime{
-		Date:  screenTime.Date,
-		AppID: screenTime.AppID,
-		Title: screenTime.Title,
-		Sleep: screenTime.Sleep,
+		StartedAt:  screenTime.Date,
+		EndedAt:    screenTime.End(),
+		AppID:      screenTime.AppID,
+		Title:      screenTime.Title,
+		Sleep:      screenTime.Sleep,
+		Host:       screenTime.Host,
+		lastSample: screenTime.Date,
 	}
 
 	return asc
@@ -28,6 +43,66 @@ func NewAggregatedScreenTimeFromScreenTime(
 func (ast *AggregatedScreenTime) AddScreenTime(
 	screenTime ScreenTime,
 ) {
-	ast.Date = screenTime.Date
+	ast.EndedAt = screenTime.End()
 	ast.Sleep += screenTime.Sleep
+	ast.lastSample = screenTime.Date
+}
+
+// Continues reports whether screenTime extends the session: the same
+// window on the same machine and day, taken at most maxGap after the
+// previous sample was (after the end of a stored session)
+func (ast *AggregatedScreenTime) Continues(
+	screenTime ScreenTime,
+	maxGap time.Duration,
+) bool {
+	if ast.AppID != screenTime.AppID {
+		return false
+	}
+
+	if ast.Title != screenTime.Title {
+		return false
+	}
+
+	if ast.Host != screenTime.Host {
+		return false
+	}
+
+	last := ast.lastSample
+	if last.IsZero() {
+		last = ast.EndedAt
+	}
+	if screenTime.Date.Sub(last) > maxGap {
+		return false
+	}
+
+	if ast.StartedAt.Format("2006-01-02") != screenTime.Date.Format("2006-01-02") {
+		return false
+	}
+
+	return true
+}
+
+// ClippedSleep returns the part of Sleep that falls into [from, to],
+// assuming the time is spread evenly over the session
+func (ast *AggregatedScreenTime) ClippedSleep(from, to time.Time) int {
+	start := ast.StartedAt
+	if from.After(start) {
+		start = from
+	}
+
+	end := ast.EndedAt
+	if to.Before(end) {
+		end = to
+	}
+
+	if !end.After(start) {
+		return 0
+	}
+
+	duration := ast.EndedAt.Sub(ast.StartedAt)
+	if duration <= 0 || end.Sub(start) >= duration {
+		return ast.Sleep
+	}
+
+	return int(float64(ast.Sleep) * float64(end.Sub(start)) / float64(duration))
 }
@@ -8,4 +8,11 @@ type ScreenTime struct {
 	AppID string
 	Title string
 	Sleep int
+	// Host - machine the sample was recorded on
+	Host string
+}
+
+// End returns the moment the sample stops covering
+func (st ScreenTime) End() time.Time {
+	return st.Date.Add(time.Duration(st.Sleep) * time.Millisecond)
 }
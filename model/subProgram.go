@@ -1,7 +1,36 @@
 package model
 
+// SubProgram - rule that renames an application (or some of its windows)
+// in reports. An app_id matches if it is listed in AppIDs or matches any
+// glob/regex; a title matches if any of TitleList (substring), TitleGlob
+// or TitleRegex matches, no title matchers means any title. Exclusions
+// veto the match. Rules are tried by descending Priority, then in file
+// order. Rewrite is a template over the capture groups of the matching
+// TitleRegex ("$1", "${repo}") that replaces Alias.
 type SubProgram struct {
 	AppIDs    []string `json:"app_ids" yaml:"app_ids"`
 	TitleList []string `json:"title_list" yaml:"title_list"`
 	Alias     string   `json:"alias" yaml:"alias"`
+
+	AppIDGlob         []string `json:"app_id_glob,omitempty" yaml:"app_id_glob,omitempty"`
+	AppIDRegex        []string `json:"app_id_regex,omitempty" yaml:"app_id_regex,omitempty"`
+	TitleGlob         []string `json:"title_glob,omitempty" yaml:"title_glob,omitempty"`
+	TitleRegex        []string `json:"title_regex,omitempty" yaml:"title_regex,omitempty"`
+	ExcludeTitleList  []string `json:"exclude_title_list,omitempty" yaml:"exclude_title_list,omitempty"`
+	ExcludeTitleRegex []string `json:"exclude_title_regex,omitempty" yaml:"exclude_title_regex,omitempty"`
+	IgnoreCase        bool     `json:"ignore_case,omitempty" yaml:"ignore_case,omitempty"`
+	Priority          int      `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Rewrite           string   `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+}
+
+// HasPatterns reports whether the rule uses anything beyond exact
+// app_ids and case-sensitive title substrings
+func (sp SubProgram) HasPatterns() bool {
+	return len(sp.AppIDGlob) > 0 ||
+		len(sp.AppIDRegex) > 0 ||
+		len(sp.TitleGlob) > 0 ||
+		len(sp.TitleRegex) > 0 ||
+		len(sp.ExcludeTitleList) > 0 ||
+		len(sp.ExcludeTitleRegex) > 0 ||
+		sp.IgnoreCase
 }
@@ -0,0 +1,19 @@
+package model
+
+// DailyUsage - time spent in one window title during one day. Day is the
+// date the sessions started on (YYYY-MM-DD).
+type DailyUsage struct {
+	Day      string
+	Host     string
+	AppID    string
+	Title    string
+	Sleep    int
+	Sessions int
+}
+
+// AppUsage - time spent in an application
+type AppUsage struct {
+	AppID    string
+	Sleep    int
+	Sessions int
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Named periods of -period. this-* and ytd end with today, last-7d and
+// last-30d are whole days including today.
+const (
+	periodToday     = "today"
+	periodYesterday = "yesterday"
+	periodThisWeek  = "this-week"
+	periodLastWeek  = "last-week"
+	periodThisMonth = "this-month"
+	periodLastMonth = "last-month"
+	periodLast7d    = "last-7d"
+	periodLast30d   = "last-30d"
+	periodYTD       = "ytd"
+)
+
+var periodNames = []string{
+	periodToday,
+	periodYesterday,
+	periodThisWeek,
+	periodLastWeek,
+	periodThisMonth,
+	periodLastMonth,
+	periodLast7d,
+	periodLast30d,
+	periodYTD,
+}
+
+const (
+	daysPerWeek = 7
+	// days of the last-7d and last-30d periods
+	shortPeriodDays = 7
+	longPeriodDays  = 30
+)
+
+// dateTimeFormats are accepted by -from and -to next to a plain date
+var dateTimeFormats = []string{
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+}
+
+// periodFlags - the period of reports and export: a named -period, a
+// -since duration or -from/-to
+type periodFlags struct {
+	from   string
+	to     string
+	period string
+	since  string
+}
+
+// addPeriodFlags adds the period flags to fs, byDefault describes a missing
+// -from or -to
+func addPeriodFlags(fs *flag.FlagSet, byDefault string) *periodFlags {
+	p := &periodFlags{}
+
+	fs.StringVar(&p.from, "from", "", "Start date or time (format: 2006-01-02 or 2006-01-02T15:04), "+byDefault)
+	fs.StringVar(&p.to, "to", "", "End date, inclusive, or time (format: 2006-01-02 or 2006-01-02T15:04), "+byDefault)
+	fs.StringVar(&p.period, "period", "", "Named period: "+strings.Join(periodNames, ", "))
+	fs.StringVar(&p.since, "since", "", "Period ending now (e.g. 90m, 3h, 2d, 1w)")
+
+	return p
+}
+
+// resolve returns the selected period, today if nothing is selected.
+// Weeks start on weekStart.
+func (p *periodFlags) resolve(now time.Time, weekStart time.Weekday) (from, to time.Time, err error) {
+	switch {
+	case p.period != "" && (p.since != "" || p.from != "" || p.to != ""):
+		return from, to, errors.New("-period cannot be combined with -since, -from or -to")
+	case p.since != "" && (p.from != "" || p.to != ""):
+		return from, to, errors.New("-since cannot be combined with -from or -to")
+	case p.period != "":
+		return namedPeriod(p.period, now, weekStart)
+	case p.since != "":
+		// whole seconds, so the period does not last 2h 59m 59.999s
+		now = now.Truncate(time.Second)
+		from, err := parseSince(p.since, now)
+		return from, now, err
+	}
+
+	return parseDates(p.from, p.to, now)
+}
+
+// bounds returns the selected period for commands that select everything
+// by default: a missing -from or -to leaves that side open (nil)
+func (p *periodFlags) bounds(now time.Time, weekStart time.Weekday) (from, to *time.Time, err error) {
+	if p.period != "" || p.since != "" {
+		start, end, err := p.resolve(now, weekStart)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &start, &end, nil
+	}
+
+	if p.from != "" {
+		start, err := parseFrom(p.from)
+		if err != nil {
+			return nil, nil, err
+		}
+		from = &start
+	}
+
+	if p.to != "" {
+		end, err := parseTo(p.to)
+		if err != nil {
+			return nil, nil, err
+		}
+		to = &end
+	}
+
+	if from != nil && to != nil && to.Before(*from) {
+		return nil, nil, errors.New("from date is after to date")
+	}
+
+	return from, to, nil
+}
+
+// namedPeriod returns the bounds of a -period value around now
+func namedPeriod(name string, now time.Time, weekStart time.Weekday) (from, to time.Time, err error) {
+	today := startOfDay(now)
+	week := today.AddDate(0, 0, -((int(today.Weekday()) - int(weekStart) + daysPerWeek) % daysPerWeek))
+	month := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+
+	switch name {
+	case periodToday:
+		return today, endOfDay(today), nil
+	case periodYesterday:
+		yesterday := today.AddDate(0, 0, -1)
+		return yesterday, endOfDay(yesterday), nil
+	case periodThisWeek:
+		return week, endOfDay(today), nil
+	case periodLastWeek:
+		return week.AddDate(0, 0, -daysPerWeek), week.Add(-time.Nanosecond), nil
+	case periodThisMonth:
+		return month, endOfDay(today), nil
+	case periodLastMonth:
+		return month.AddDate(0, -1, 0), month.Add(-time.Nanosecond), nil
+	case periodLast7d:
+		return today.AddDate(0, 0, 1-shortPeriodDays), endOfDay(today), nil
+	case periodLast30d:
+		return today.AddDate(0, 0, 1-longPeriodDays), endOfDay(today), nil
+	case periodYTD:
+		return time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location()), endOfDay(today), nil
+	}
+
+	return from, to, fmt.Errorf("unknown period %q (%s)", name, strings.Join(periodNames, ", "))
+}
+
+// parseSince returns the start of a -since period ending at now: anything
+// time.ParseDuration accepts or whole calendar days (2d) or weeks (1w), so
+// a day is not 24 hours across a daylight saving time change
+func parseSince(value string, now time.Time) (time.Time, error) {
+	var from time.Time
+	var err error
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		from, err = daysBefore(now, days, 1)
+	} else if weeks, ok := strings.CutSuffix(value, "w"); ok {
+		from, err = daysBefore(now, weeks, daysPerWeek)
+	} else {
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		from = now.Add(-d)
+	}
+	if err != nil || !from.Before(now) {
+		return from, fmt.Errorf("invalid -since value %q, expected a positive duration like 90m, 3h, 2d or 1w", value)
+	}
+
+	return from, nil
+}
+
+// daysBefore returns now value times days calendar days ago
+func daysBefore(now time.Time, value string, days int) (time.Time, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return now, err
+	}
+
+	return now.AddDate(0, 0, -n*days), nil
+}
+
+// parseDates reads -from and -to. A date without time covers the whole
+// day, a missing value means today.
+func parseDates(fromStr, toStr string, now time.Time) (from, to time.Time, err error) {
+	today := startOfDay(now)
+
+	from = today
+	if fromStr != "" {
+		if from, err = parseFrom(fromStr); err != nil {
+			return from, to, err
+		}
+	}
+
+	to = endOfDay(today)
+	if toStr != "" {
+		if to, err = parseTo(toStr); err != nil {
+			return from, to, err
+		}
+	}
+
+	if to.Before(from) {
+		return from, to, errors.New("from date is after to date")
+	}
+
+	return from, to, nil
+}
+
+func parseFrom(value string) (time.Time, error) {
+	from, err := parseDateTime(value)
+	if err != nil {
+		return from, fmt.Errorf("invalid from date: %w", err)
+	}
+
+	return from, nil
+}
+
+// parseTo reads -to, a date without time covers the whole day
+func parseTo(value string) (time.Time, error) {
+	to, err := parseDateTime(value)
+	if err != nil {
+		return to, fmt.Errorf("invalid to date: %w", err)
+	}
+	if _, dateOnly := parseDate(value); dateOnly {
+		to = endOfDay(to)
+	}
+
+	return to, nil
+}
+
+// parseDateTime reads a date or a date with time in the local zone
+func parseDateTime(value string) (time.Time, error) {
+	if date, ok := parseDate(value); ok {
+		return date, nil
+	}
+
+	for _, layout := range dateTimeFormats {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q is neither 2006-01-02 nor 2006-01-02T15:04", value)
+}
+
+func parseDate(value string) (time.Time, bool) {
+	date, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	return date, err == nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// endOfDay returns the last moment of the day starting at start
+func endOfDay(start time.Time) time.Time {
+	return start.AddDate(0, 0, 1).Add(-time.Nanosecond)
+}
@@ -0,0 +1,141 @@
+// Package privacy drops or redacts sensitive samples before they are
+// stored: private browser windows, password managers and user rules.
+package privacy
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// DefaultPlaceholder replaces redacted titles when a rule sets no placeholder
+const DefaultPlaceholder = "[private]"
+
+// chromiumAppIDs - Chromium-based browsers, their private window titles
+// only differ by a suffix that other apps may use too
+const chromiumAppIDs = `(?i)(chrom|brave|vivaldi|edge|opera|thorium|yandex)`
+
+// builtinRules - private browsing windows and password managers
+var builtinRules = []config.PrivacyRule{
+	{
+		TitleRegex: `(?i)(Mozilla Firefox|LibreWolf|Zen Browser) Private Browsing$`,
+		Action:     config.PrivacyActionRedact,
+	},
+	{
+		// "Page - Chromium (Incognito)", "Page - Vivaldi (Private)"
+		AppIDRegex: chromiumAppIDs,
+		TitleRegex: `(?i) \((Incognito|InPrivate|Private)\)$`,
+		Action:     config.PrivacyActionRedact,
+	},
+	{
+		// "Page - [InPrivate] - Microsoft Edge"
+		AppIDRegex: chromiumAppIDs,
+		TitleRegex: `(?i) - \[InPrivate\]( - |$)`,
+		Action:     config.PrivacyActionRedact,
+	},
+	{
+		AppIDRegex: `(?i)^(org\.keepassxc\.KeePassXC|keepassxc|1password|bitwarden|com\.bitwarden\.desktop)$`,
+		Action:     config.PrivacyActionAppOnly,
+	},
+}
+
+type rule struct {
+	appIDs      []string
+	appIDRegex  *regexp.Regexp
+	titleRegex  *regexp.Regexp
+	action      string
+	placeholder string
+}
+
+type Filter struct {
+	rules []rule
+}
+
+// NewFilter compiles the rules, the patterns are validated by
+// config.Validate
+func NewFilter(cfg config.Privacy) (*Filter, error) {
+	rules := slices.Clone(cfg.Rules)
+	if cfg.BuiltinRules {
+		rules = append(rules, builtinRules...)
+	}
+
+	f := &Filter{}
+	for _, r := range rules {
+		compiled, err := compileRule(r)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, compiled)
+	}
+
+	return f, nil
+}
+
+func compileRule(r config.PrivacyRule) (rule, error) {
+	compiled := rule{
+		appIDs:      r.AppIDs,
+		action:      r.Action,
+		placeholder: r.Placeholder,
+	}
+
+	if compiled.placeholder == "" {
+		compiled.placeholder = DefaultPlaceholder
+	}
+
+	var err error
+	if r.AppIDRegex != "" {
+		if compiled.appIDRegex, err = regexp.Compile(r.AppIDRegex); err != nil {
+			return rule{}, err
+		}
+	}
+
+	if r.TitleRegex != "" {
+		if compiled.titleRegex, err = regexp.Compile(r.TitleRegex); err != nil {
+			return rule{}, err
+		}
+	}
+
+	return compiled, nil
+}
+
+func (r *rule) match(st model.ScreenTime) bool {
+	if len(r.appIDs) > 0 && !slices.Contains(r.appIDs, st.AppID) {
+		return false
+	}
+
+	if r.appIDRegex != nil && !r.appIDRegex.MatchString(st.AppID) {
+		return false
+	}
+
+	if r.titleRegex != nil && !r.titleRegex.MatchString(st.Title) {
+		return false
+	}
+
+	return true
+}
+
+// Apply returns the sample as it may be stored, ok is false if the
+// sample must be dropped. The first matching rule wins.
+func (f *Filter) Apply(st model.ScreenTime) (model.ScreenTime, bool) {
+	for i := range f.rules {
+		r := &f.rules[i]
+		if !r.match(st) {
+			continue
+		}
+
+		switch r.action {
+		case config.PrivacyActionDrop:
+			return st, false
+		case config.PrivacyActionRedact:
+			st.Title = r.placeholder
+		case config.PrivacyActionAppOnly:
+			st.Title = ""
+		}
+
+		return st, true
+	}
+
+	return st, true
+}
@@ -0,0 +1,49 @@
+package privacy
+
+import (
+	"testing"
+
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+func TestBuiltinPrivateWindowRules(t *testing.T) {
+	filter, err := NewFilter(config.Privacy{BuiltinRules: true})
+	if err != nil {
+		t.Fatalf("NewFilter: %v", err)
+	}
+
+	tests := []struct {
+		appID  string
+		title  string
+		redact bool
+	}{
+		{appID: "firefox", title: "Search — Mozilla Firefox Private Browsing", redact: true},
+		{appID: "librewolf", title: "Search — LibreWolf Private Browsing", redact: true},
+		{appID: "google-chrome", title: "Search - Google Chrome (Incognito)", redact: true},
+		{appID: "chromium", title: "New Tab - Chromium (Incognito)", redact: true},
+		{appID: "brave-browser", title: "Search - Brave (Private)", redact: true},
+		{appID: "vivaldi-stable", title: "Search - Vivaldi (Private)", redact: true},
+		{appID: "microsoft-edge", title: "Search - [InPrivate] - Microsoft Edge", redact: true},
+		{appID: "firefox", title: "Search — Mozilla Firefox"},
+		{appID: "google-chrome", title: "Search - Google Chrome"},
+		{appID: "google-chrome", title: "Team - Private"},
+		{appID: "org.telegram.desktop", title: "Team chat - Private"},
+		{appID: "Slack", title: "#general - Private"},
+		{appID: "org.gnome.TextEditor", title: "notes (Private)"},
+		{appID: "libreoffice-writer", title: "Budget [Private]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.appID+" "+tt.title, func(t *testing.T) {
+			st, ok := filter.Apply(model.ScreenTime{AppID: tt.appID, Title: tt.title})
+			if !ok {
+				t.Fatal("the sample is dropped")
+			}
+
+			if redacted := st.Title == DefaultPlaceholder; redacted != tt.redact {
+				t.Errorf("Apply() title = %q, redact %v", st.Title, tt.redact)
+			}
+		})
+	}
+}
@@ -26,55 +26,45 @@ func NewResponseManager(
 	return &r
 }
 
+// GetReport writes time per application. host limits the report to one
+// machine, perHost reports every application once per machine.
 func (r *reportManager) GetReport(
-	dbScreenTime *db.ScreenTimeDB,
-	dbAggregate *db.AggregatedScreenTimeDB,
+	usageDB db.UsageStore,
 	from *time.Time,
 	to *time.Time,
+	host string,
+	perHost bool,
 ) error {
 	resp := map[string]model.Report{}
 
-	screenTimeList, err := dbScreenTime.GetByDateRange(
-		from,
-		to,
-	)
-
-	if err != nil {
-		return err
-	}
-
-	aggregate, err := dbAggregate.GetByDateRange(
-		from,
-		to,
-	)
-	if err != nil {
-		return err
-	}
-
-	for _, a := range aggregate {
-		screenTimeList = append(screenTimeList, model.ScreenTime(a))
-	}
-
-	summary := 0
 	subProgram, err := subprogrammanager.NewSubProgramManager()
 
 	if err != nil {
 		return err
 	}
 
-	for _, st := range screenTimeList {
-		summary += st.Sleep
+	err = usageDB.ForEach(from, to, db.UsageFilter{Host: host}, func(st model.ScreenTime) error {
 		st = subProgram.GetSubProgram(st)
 
-		if report, ok := resp[st.AppID]; ok {
+		name := st.AppID
+		if perHost {
+			name += " [" + st.Host + "]"
+		}
+
+		if report, ok := resp[name]; ok {
 			report.TimeMs += st.Sleep
-			resp[st.AppID] = report
+			resp[name] = report
 		} else {
-			resp[st.AppID] = model.Report{
-				Name:   st.AppID,
+			resp[name] = model.Report{
+				Name:   name,
 				TimeMs: st.Sleep,
 			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	responseSlice := []model.Report{}
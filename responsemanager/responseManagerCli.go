@@ -15,20 +15,24 @@ import (
 )
 
 type responseManagerCli struct {
-	from  *time.Time
-	to    *time.Time
-	limit int
+	from           *time.Time
+	to             *time.Time
+	limit          int
+	truncateLength int
 }
 
+// NewResponseManagerCli - truncateLength 0 disables truncation of names
 func NewResponseManagerCli(
 	from *time.Time,
 	to *time.Time,
 	limit int,
+	truncateLength int,
 ) *responseManagerCli {
 	r := responseManagerCli{}
 	r.from = from
 	r.to = to
 	r.limit = limit
+	r.truncateLength = truncateLength
 
 	return &r
 }
@@ -119,10 +123,10 @@ func (*responseManagerCli) formatDuration(ms int) string {
 	return strings.Join(parts, " ")
 }
 
-func (*responseManagerCli) truncateString(s string) string {
-	maxLength := 80
+func (r *responseManagerCli) truncateString(s string) string {
+	maxLength := r.truncateLength
 	// Если строка короче или равна максимальной длине, возвращаем как есть
-	if utf8.RuneCountInString(s) <= maxLength {
+	if maxLength == 0 || utf8.RuneCountInString(s) <= maxLength {
 		return s
 	}
 
@@ -0,0 +1,93 @@
+// Package retentionmanager keeps the database bounded: old sessions are
+// rolled up into daily summaries and data beyond the retention horizon
+// is deleted.
+package retentionmanager
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/db"
+)
+
+type retentionManager struct {
+	retentionDB db.RetentionDB
+	mutex       sync.Mutex
+	settings    config.Retention
+}
+
+func NewRetentionManager(
+	retentionDB db.RetentionDB,
+	settings config.Retention,
+) *retentionManager {
+	rm := &retentionManager{}
+	rm.retentionDB = retentionDB
+	rm.settings = settings
+
+	return rm
+}
+
+// SetSettings applies new settings starting with the next run
+func (rm *retentionManager) SetSettings(settings config.Retention) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.settings = settings
+}
+
+func (rm *retentionManager) getSettings() config.Retention {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	return rm.settings
+}
+
+// Run prunes the database every interval until ctx is canceled
+func (rm *retentionManager) Run(ctx context.Context) {
+	fn := "retentionManager:Run"
+
+	for {
+		settings := rm.getSettings()
+
+		result, err := rm.Prune(settings, false)
+		if err != nil {
+			log.Println(fn, err)
+		} else if result != (db.PruneResult{}) {
+			log.Printf("%s: %d sessions rolled up, %d sessions, %d samples and %d daily summaries deleted",
+				fn, result.RolledUp, result.Sessions, result.Samples, result.Summaries)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(settings.Interval):
+		}
+	}
+}
+
+// Prune applies settings once, with dryRun nothing is changed
+func (rm *retentionManager) Prune(settings config.Retention, dryRun bool) (db.PruneResult, error) {
+	rollupBefore, deleteBefore := Cutoffs(settings, time.Now())
+
+	return rm.retentionDB.Prune(rollupBefore, deleteBefore, dryRun)
+}
+
+// Cutoffs returns the start of the oldest day kept as sessions and the
+// start of the oldest day kept at all, zero means no limit. Whole days
+// are kept, so a rolled-up day never mixes with sessions.
+func Cutoffs(settings config.Retention, now time.Time) (rollupBefore, deleteBefore time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	if settings.SessionDays > 0 {
+		rollupBefore = today.AddDate(0, 0, -settings.SessionDays)
+	}
+
+	if settings.SummaryDays > 0 {
+		deleteBefore = today.AddDate(0, 0, -settings.SummaryDays)
+	}
+
+	return rollupBefore, deleteBefore
+}
@@ -0,0 +1,286 @@
+package subprogrammanager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/probeldev/niri-screen-time/config"
+	"github.com/probeldev/niri-screen-time/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Check validates subprograms.{yaml,yml,json} in the config directory:
+// syntax and type errors with positions, shadowed files, unknown keys,
+// duplicate aliases and rules that can never match.
+func Check() []config.Issue {
+	configDir, err := config.Dir()
+	if err != nil {
+		return []config.Issue{{File: "subprograms", Severity: config.SeverityError, Message: err.Error()}}
+	}
+
+	issues := []config.Issue{}
+	active := ""
+
+	for _, ext := range configExtensions {
+		configFile := filepath.Join(configDir, "subprograms"+ext)
+		if _, err := os.Stat(configFile); err != nil {
+			continue
+		}
+
+		if active != "" {
+			issues = append(issues, config.Issue{
+				File:     configFile,
+				Severity: config.SeverityWarning,
+				Message:  "file is ignored because " + filepath.Base(active) + " takes priority",
+			})
+			continue
+		}
+
+		active = configFile
+		issues = append(issues, checkFile(configFile, ext == ".json")...)
+	}
+
+	return issues
+}
+
+func checkFile(configFile string, isJSON bool) []config.Issue {
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		return []config.Issue{{File: configFile, Severity: config.SeverityError, Message: err.Error()}}
+	}
+
+	root, issues := config.ParseNode(configFile, content, isJSON)
+	if root == nil {
+		return issues
+	}
+
+	var programs []model.SubProgram
+
+	issues = append(issues, config.UnknownKeys(configFile, root, reflect.TypeOf(programs))...)
+
+	if err := root.Decode(&programs); err != nil {
+		return append(issues, config.YAMLIssues(configFile, root, err)...)
+	}
+
+	return append(issues, checkRules(configFile, root, programs)...)
+}
+
+func checkRules(configFile string, root *yaml.Node, programs []model.SubProgram) []config.Issue {
+	issues := []config.Issue{}
+	aliases := map[string]*yaml.Node{}
+
+	for i, p := range programs {
+		node := root.Content[i]
+
+		aliasNode := valueNode(node, "alias")
+		if p.Alias == "" {
+			issues = append(issues, config.NewIssue(configFile, node, config.SeverityError, "rule has no alias"))
+		} else if first, ok := aliases[p.Alias]; ok {
+			issues = append(issues, config.NewIssue(configFile, aliasNode, config.SeverityWarning,
+				"duplicate alias %q, first defined at line %d", p.Alias, first.Line))
+		} else {
+			aliases[p.Alias] = aliasNode
+		}
+
+		issues = append(issues, checkPatterns(configFile, node, p)...)
+
+		if len(p.AppIDs) == 0 && len(p.AppIDGlob) == 0 && len(p.AppIDRegex) == 0 {
+			issues = append(issues, config.NewIssue(configFile, node, config.SeverityWarning,
+				"rule %q has no app_ids and can never match", p.Alias))
+		}
+	}
+
+	// shadowing is only decidable for rules without patterns, they are
+	// checked against earlier plain rules in the order GetSubProgram uses
+	order := make([]int, len(programs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return programs[order[a]].Priority > programs[order[b]].Priority
+	})
+
+	earlier := []model.SubProgram{}
+	for _, i := range order {
+		p := programs[i]
+		if p.HasPatterns() {
+			continue
+		}
+
+		if len(p.AppIDs) > 0 {
+			issues = append(issues, checkShadowed(configFile, root.Content[i], earlier, p)...)
+		}
+		earlier = append(earlier, p)
+	}
+
+	return issues
+}
+
+// checkPatterns reports every glob and regex that fails to compile
+func checkPatterns(configFile string, node *yaml.Node, p model.SubProgram) []config.Issue {
+	issues := []config.Issue{}
+
+	check := func(key string, patterns []string, compile func(string, bool) (*regexp.Regexp, error)) {
+		listNode := valueNode(node, key)
+		for j, pattern := range patterns {
+			_, err := compile(pattern, p.IgnoreCase)
+			if err == nil {
+				continue
+			}
+
+			var itemNode *yaml.Node
+			if listNode != nil && j < len(listNode.Content) {
+				itemNode = listNode.Content[j]
+			}
+			issues = append(issues, config.NewIssue(configFile, itemNode, config.SeverityError, "%s: %v", key, err))
+		}
+	}
+
+	check("app_id_glob", p.AppIDGlob, CompileGlob)
+	check("app_id_regex", p.AppIDRegex, CompileRegex)
+	check("title_glob", p.TitleGlob, CompileGlob)
+	check("title_regex", p.TitleRegex, CompileRegex)
+	check("exclude_title_regex", p.ExcludeTitleRegex, CompileRegex)
+
+	return append(issues, checkRewrite(configFile, node, p)...)
+}
+
+// checkRewrite reports templates that can never expand and references
+// to capture groups missing from every title_regex
+func checkRewrite(configFile string, node *yaml.Node, p model.SubProgram) []config.Issue {
+	if p.Rewrite == "" {
+		return nil
+	}
+
+	rewriteNode := valueNode(node, "rewrite")
+	if len(p.TitleRegex) == 0 {
+		return []config.Issue{config.NewIssue(configFile, rewriteNode, config.SeverityWarning,
+			"rewrite has no effect without title_regex")}
+	}
+
+	issues := []config.Issue{}
+	for _, m := range templateGroupPattern.FindAllStringSubmatch(p.Rewrite, -1) {
+		group := m[1] + m[2]
+		if hasGroup(p, group) {
+			continue
+		}
+
+		issues = append(issues, config.NewIssue(configFile, rewriteNode, config.SeverityWarning,
+			"rewrite refers to group %q missing from every title_regex", group))
+	}
+
+	return issues
+}
+
+var templateGroupPattern = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+
+func hasGroup(p model.SubProgram, group string) bool {
+	for _, expr := range p.TitleRegex {
+		re, err := CompileRegex(expr, p.IgnoreCase)
+		if err != nil {
+			continue
+		}
+
+		if n, err := strconv.Atoi(group); err == nil {
+			if n <= re.NumSubexp() {
+				return true
+			}
+			continue
+		}
+
+		if re.SubexpIndex(group) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkShadowed reports a rule whose every app_id/title combination is
+// already claimed by an earlier rule, GetSubProgram uses the first match
+func checkShadowed(
+	configFile string,
+	node *yaml.Node,
+	earlier []model.SubProgram,
+	p model.SubProgram,
+) []config.Issue {
+	issues := []config.Issue{}
+	titlesNode := valueNode(node, "title_list")
+	reachable := false
+
+	for _, appID := range p.AppIDs {
+		if len(p.TitleList) == 0 {
+			if !coveredTitle(earlier, appID, nil) {
+				reachable = true
+			}
+			continue
+		}
+
+		for j, title := range p.TitleList {
+			if !coveredTitle(earlier, appID, &title) {
+				reachable = true
+				continue
+			}
+
+			var titleNode *yaml.Node
+			if titlesNode != nil && j < len(titlesNode.Content) {
+				titleNode = titlesNode.Content[j]
+			}
+			issues = append(issues, config.NewIssue(configFile, titleNode, config.SeverityWarning,
+				"title %q for %q is already matched by an earlier rule", title, appID))
+		}
+	}
+
+	if !reachable {
+		return []config.Issue{config.NewIssue(configFile, node, config.SeverityWarning,
+			"rule %q can never match, earlier rules cover all of its app_ids and titles", p.Alias)}
+	}
+
+	return issues
+}
+
+// coveredTitle reports whether an earlier rule always wins for appID and
+// title (nil title stands for "any title")
+func coveredTitle(earlier []model.SubProgram, appID string, title *string) bool {
+	for _, e := range earlier {
+		if !slices.Contains(e.AppIDs, appID) {
+			continue
+		}
+
+		if len(e.TitleList) == 0 {
+			return true
+		}
+
+		if title == nil {
+			continue
+		}
+
+		for _, t := range e.TitleList {
+			if strings.Contains(*title, t) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func valueNode(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
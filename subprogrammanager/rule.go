@@ -0,0 +1,232 @@
+package subprogrammanager
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/probeldev/niri-screen-time/model"
+)
+
+// rule - compiled model.SubProgram
+type rule struct {
+	program       model.SubProgram
+	appIDs        []string
+	appIDPatterns []*regexp.Regexp
+	titles        []string
+	titlePatterns []*regexp.Regexp
+	titleRegexps  []*regexp.Regexp
+	excludeTitles []string
+	excludes      []*regexp.Regexp
+}
+
+func compileRule(p model.SubProgram) (rule, error) {
+	r := rule{
+		program:       p,
+		appIDs:        foldAll(p.AppIDs, p.IgnoreCase),
+		titles:        foldAll(p.TitleList, p.IgnoreCase),
+		excludeTitles: foldAll(p.ExcludeTitleList, p.IgnoreCase),
+	}
+
+	var err error
+
+	if r.appIDPatterns, err = compilePatterns(p.AppIDGlob, p.AppIDRegex, p.IgnoreCase); err != nil {
+		return rule{}, fmt.Errorf("rule %q: %w", p.Alias, err)
+	}
+
+	if r.titlePatterns, err = compilePatterns(p.TitleGlob, p.TitleRegex, p.IgnoreCase); err != nil {
+		return rule{}, fmt.Errorf("rule %q: %w", p.Alias, err)
+	}
+
+	if r.titleRegexps, err = compilePatterns(nil, p.TitleRegex, p.IgnoreCase); err != nil {
+		return rule{}, fmt.Errorf("rule %q: %w", p.Alias, err)
+	}
+
+	if r.excludes, err = compilePatterns(nil, p.ExcludeTitleRegex, p.IgnoreCase); err != nil {
+		return rule{}, fmt.Errorf("rule %q: %w", p.Alias, err)
+	}
+
+	return r, nil
+}
+
+func compilePatterns(globs, regexps []string, ignoreCase bool) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+
+	for _, g := range globs {
+		re, err := CompileGlob(g, ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+
+	for _, expr := range regexps {
+		re, err := CompileRegex(expr, ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+
+	return patterns, nil
+}
+
+// CompileRegex compiles a rule regexp, unanchored like title_list substrings
+func CompileRegex(expr string, ignoreCase bool) (*regexp.Regexp, error) {
+	flags := ""
+	if ignoreCase {
+		flags = "(?i)"
+	}
+
+	re, err := regexp.Compile(flags + expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", expr, err)
+	}
+
+	return re, nil
+}
+
+// CompileGlob compiles a shell-style pattern matching the whole string:
+// "*" is any run of characters (including "/"), "?" one character and
+// "[...]" a character class, "[!...]" a negated one
+func CompileGlob(glob string, ignoreCase bool) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	inClass := false
+	classStart := false
+	for _, c := range glob {
+		switch {
+		case classStart && c == '!':
+			// shell negation [!...] is [^...] in a regexp
+			classStart = false
+			expr.WriteRune('^')
+		case inClass:
+			classStart = false
+			if c == ']' {
+				inClass = false
+			}
+			if c == '\\' {
+				expr.WriteString(`\\`)
+				continue
+			}
+			expr.WriteRune(c)
+		case c == '*':
+			expr.WriteString(".*")
+		case c == '?':
+			expr.WriteString(".")
+		case c == '[':
+			inClass = true
+			classStart = true
+			expr.WriteRune(c)
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	if inClass {
+		return nil, fmt.Errorf("invalid glob %q: unterminated [", glob)
+	}
+
+	expr.WriteString("$")
+
+	re, err := CompileRegex(expr.String(), ignoreCase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", glob, err)
+	}
+
+	return re, nil
+}
+
+func foldAll(list []string, ignoreCase bool) []string {
+	if !ignoreCase {
+		return list
+	}
+
+	folded := make([]string, 0, len(list))
+	for _, s := range list {
+		folded = append(folded, strings.ToLower(s))
+	}
+
+	return folded
+}
+
+func (r *rule) fold(s string) string {
+	if r.program.IgnoreCase {
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+func (r *rule) matchAppID(appID string) bool {
+	if slices.Contains(r.appIDs, r.fold(appID)) {
+		return true
+	}
+
+	for _, re := range r.appIDPatterns {
+		if re.MatchString(appID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchTitle returns true if the title is accepted by the title matchers
+// and not vetoed by the exclusions
+func (r *rule) matchTitle(title string) bool {
+	folded := r.fold(title)
+
+	for _, t := range r.excludeTitles {
+		if strings.Contains(folded, t) {
+			return false
+		}
+	}
+
+	for _, re := range r.excludes {
+		if re.MatchString(title) {
+			return false
+		}
+	}
+
+	if len(r.titles) == 0 && len(r.titlePatterns) == 0 {
+		return true
+	}
+
+	for _, t := range r.titles {
+		if strings.Contains(folded, t) {
+			return true
+		}
+	}
+
+	for _, re := range r.titlePatterns {
+		if re.MatchString(title) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewrite expands the Rewrite template with the capture groups of the
+// first title_regex matching title, ok is false if there is nothing to
+// expand or the template expands to nothing (it only refers to groups
+// that did not match), so callers keep the alias or the title
+func (r *rule) rewrite(title string) (string, bool) {
+	if r.program.Rewrite == "" {
+		return "", false
+	}
+
+	for _, re := range r.titleRegexps {
+		match := re.FindStringSubmatchIndex(title)
+		if match == nil {
+			continue
+		}
+
+		result := strings.TrimSpace(string(re.ExpandString(nil, r.program.Rewrite, title, match)))
+		return result, result != ""
+	}
+
+	return "", false
+}
@@ -3,27 +3,28 @@ package subprogrammanager
 
 import (
 	"encoding/json"
+	"log"
 	"os"
 	"path/filepath"
-	"slices"
-	"strings"
+	"sort"
 
+	"github.com/probeldev/niri-screen-time/config"
 	"github.com/probeldev/niri-screen-time/model"
 	"gopkg.in/yaml.v3"
 )
 
 type SubProgramManager struct {
 	programs  []model.SubProgram
+	rules     []rule
 	configDir string
 }
 
 func NewSubProgramManager() (*SubProgramManager, error) {
-	homeDir, err := os.UserHomeDir()
+	configDir, err := config.Dir()
 	if err != nil {
 		return nil, err
 	}
 
-	configDir := filepath.Join(homeDir, ".config", "niri-screen-time")
 	spm := &SubProgramManager{
 		configDir: configDir,
 	}
@@ -32,14 +33,38 @@ func NewSubProgramManager() (*SubProgramManager, error) {
 		return nil, err
 	}
 
+	if err := spm.compileRules(); err != nil {
+		return nil, err
+	}
+
 	return spm, nil
 }
 
-func (spm *SubProgramManager) loadPrograms() error {
-	// Try YAML files first, then fall back to JSON
-	yamlExtensions := []string{".yaml", ".yml", ".json"}
+// compileRules compiles the patterns and orders rules by priority,
+// rules with equal priority keep their order from the file
+func (spm *SubProgramManager) compileRules() error {
+	spm.rules = make([]rule, 0, len(spm.programs))
+	for _, p := range spm.programs {
+		r, err := compileRule(p)
+		if err != nil {
+			return err
+		}
+		spm.rules = append(spm.rules, r)
+	}
+
+	sort.SliceStable(spm.rules, func(i, j int) bool {
+		return spm.rules[i].program.Priority > spm.rules[j].program.Priority
+	})
+
+	return nil
+}
+
+// configExtensions - supported formats in priority order, only the
+// first existing file is used
+var configExtensions = []string{".yaml", ".yml", ".json"}
 
-	for _, ext := range yamlExtensions {
+func (spm *SubProgramManager) loadPrograms() error {
+	for _, ext := range configExtensions {
 		configFile := filepath.Join(spm.configDir, "subprograms"+ext)
 		file, err := os.ReadFile(configFile)
 		if err != nil {
@@ -65,45 +90,85 @@ func (spm *SubProgramManager) loadPrograms() error {
 	return nil
 }
 
+// Rules returns the number of loaded rules
+func (spm *SubProgramManager) Rules() int {
+	return len(spm.rules)
+}
+
+// Watch reloads the rules whenever one of subprograms.{yaml,yml,json}
+// changes and passes them to onChange. A file that fails to load is
+// logged and onChange is not called, the previous rules stay in use.
+func Watch(onChange func(*SubProgramManager)) error {
+	fn := "subprogrammanager:Watch"
+
+	configDir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(configExtensions))
+	for _, ext := range configExtensions {
+		paths = append(paths, filepath.Join(configDir, "subprograms"+ext))
+	}
+
+	return config.WatchFiles(paths, func() {
+		spm, err := NewSubProgramManager()
+		if err != nil {
+			log.Println(fn, "keeping previous subprogram rules:", err)
+			return
+		}
+		onChange(spm)
+	})
+}
+
 func (spm *SubProgramManager) IsSetProgram(st model.ScreenTime) bool {
-	for _, p := range spm.programs {
-		if slices.Contains(p.AppIDs, st.AppID) {
+	for i := range spm.rules {
+		if spm.rules[i].matchAppID(st.AppID) {
 			return true
 		}
 	}
 	return false
 }
 
+// match returns the first rule accepting st
+func (spm *SubProgramManager) match(st model.ScreenTime) *rule {
+	for i := range spm.rules {
+		r := &spm.rules[i]
+
+		if r.matchAppID(st.AppID) && r.matchTitle(st.Title) {
+			return r
+		}
+	}
+
+	return nil
+}
+
 func (spm *SubProgramManager) GetSubProgram(st model.ScreenTime) model.ScreenTime {
 	if !spm.IsSetProgram(st) {
 		return st
 	}
 
-	for _, p := range spm.programs {
-		isCompareID := false
-		for _, id := range p.AppIDs {
-			if id == st.AppID {
-				isCompareID = true
-			}
-		}
-
-		if !isCompareID {
-			continue
+	if r := spm.match(st); r != nil {
+		st.AppID = r.program.Alias
+		if rewritten, ok := r.rewrite(st.Title); ok {
+			st.AppID = rewritten
 		}
+		return st
+	}
 
-		if len(p.TitleList) == 0 {
-			st.AppID = p.Alias
-			return st
-		}
+	st.AppID += " (Other)"
+	return st
+}
 
-		for _, title := range p.TitleList {
-			if strings.Contains(st.Title, title) {
-				st.AppID = p.Alias
-				return st
-			}
+// RewriteTitle replaces the title with the key extracted by the rewrite
+// template of the matching rule, so details group by that key instead
+// of by every distinct title. Titles without a rewrite are unchanged.
+func (spm *SubProgramManager) RewriteTitle(st model.ScreenTime) model.ScreenTime {
+	if r := spm.match(st); r != nil {
+		if rewritten, ok := r.rewrite(st.Title); ok {
+			st.Title = rewritten
 		}
 	}
 
-	st.AppID += " (Other)"
 	return st
 }
@@ -0,0 +1,133 @@
+package titlecrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/probeldev/niri-screen-time/config"
+)
+
+const (
+	// KeyEnv - environment variable holding the key for key_source "env"
+	KeyEnv = "NIRI_SCREEN_TIME_TITLE_KEY"
+
+	keyFileName = "title.key"
+	keySize     = 32
+)
+
+// LoadCipher returns the cipher configured in cfg, or nil if encryption
+// is disabled
+func LoadCipher(cfg config.Encryption) (*Cipher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	secret, err := loadKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCipher(secret)
+}
+
+func loadKey(cfg config.Encryption) ([]byte, error) {
+	switch cfg.KeySource {
+	case config.KeySourceFile:
+		path, err := KeyFilePath(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key: %w", err)
+		}
+		return []byte(strings.TrimSpace(string(key))), nil
+	case config.KeySourceEnv:
+		key := os.Getenv(KeyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("encryption key: %s is not set", KeyEnv)
+		}
+		return []byte(key), nil
+	case config.KeySourceSecretService:
+		return lookupSecret()
+	}
+
+	return nil, fmt.Errorf("unknown encryption key source %q", cfg.KeySource)
+}
+
+// lookupSecret runs secret-tool directly, without a shell, so the
+// secret never passes through shell configuration or history
+func lookupSecret() ([]byte, error) {
+	var stderr bytes.Buffer
+	// secret-tool reads the key from the Secret Service over D-Bus
+	cmd := exec.Command("secret-tool", "lookup", "service", "niri-screen-time", "key", "title")
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			err = fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, fmt.Errorf("failed to read encryption key from Secret Service: %w", err)
+	}
+
+	key := bytes.TrimSpace(out)
+	if len(key) == 0 {
+		return nil, errors.New("encryption key: no key stored in Secret Service (service niri-screen-time, key title)")
+	}
+
+	return key, nil
+}
+
+// KeyFilePath returns encryption.key_file or title.key in the config directory
+func KeyFilePath(cfg config.Encryption) (string, error) {
+	if cfg.KeyFile != "" {
+		return cfg.KeyFile, nil
+	}
+
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, keyFileName), nil
+}
+
+// GenerateKey returns a new random key in printable form
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// WriteKeyFile stores a new key readable only by the owner, an existing
+// key is never overwritten because it would make stored titles unreadable
+func WriteKeyFile(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.New("key file already exists: " + path)
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	var dirPerm os.FileMode = 0700
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return err
+	}
+
+	var filePerm os.FileMode = 0600
+	return os.WriteFile(path, []byte(key+"\n"), filePerm)
+}
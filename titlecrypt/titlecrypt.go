@@ -0,0 +1,105 @@
+// Package titlecrypt encrypts window titles at rest. Titles are sealed
+// with AES-256-GCM under a random nonce, so equal titles produce
+// different ciphertexts; a keyed HMAC-SHA256 of the title is stored next
+// to it for grouping and searching without decryption.
+package titlecrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks encrypted values, anything else is a legacy plaintext title
+const prefix = "enc:v1:"
+
+var ErrWrongKey = errors.New("failed to decrypt title: wrong encryption key or corrupted value")
+
+type Cipher struct {
+	aead    cipher.AEAD
+	hashKey []byte
+}
+
+// NewCipher derives independent encryption and hashing keys from secret
+func NewCipher(secret []byte) (*Cipher, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("encryption key is empty")
+	}
+
+	master := sha256.Sum256(secret)
+
+	block, err := aes.NewCipher(derive(master[:], "niri-screen-time title encryption"))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{
+		aead:    aead,
+		hashKey: derive(master[:], "niri-screen-time title hash"),
+	}, nil
+}
+
+func derive(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Encrypt seals title into a printable string
+func (c *Cipher) Encrypt(title string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(title), nil)
+
+	return prefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt, plaintext values are
+// returned unchanged
+func (c *Cipher) Decrypt(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, prefix)
+	if !ok {
+		return stored, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", ErrWrongKey
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+
+	title, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+
+	return string(title), nil
+}
+
+// Hash returns the keyed hash used to group equal titles
+func (c *Cipher) Hash(title string) string {
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write([]byte(title))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted reports whether a stored value was produced by Encrypt
+func IsEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, prefix)
+}
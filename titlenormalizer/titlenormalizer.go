@@ -0,0 +1,136 @@
+// Package titlenormalizer cleans window titles up before they are grouped:
+// unread counters, browser and editor suffixes, user-defined regexp
+// replacements and repeated whitespace.
+package titlenormalizer
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/probeldev/niri-screen-time/config"
+)
+
+// defaultAppSuffixes - application names appended to window titles
+var defaultAppSuffixes = []string{
+	"Mozilla Firefox",
+	"Mozilla Firefox Private Browsing",
+	"Google Chrome",
+	"Chromium",
+	"Brave",
+	"Zen Browser",
+	"LibreWolf",
+	"Visual Studio Code",
+	"Obsidian",
+}
+
+var (
+	// "(3) Inbox", "[12] Chat", "Inbox (99+)"
+	leadingCounter  = regexp.MustCompile(`^\s*[(\[]\d+\+?[)\]]\s*`)
+	trailingCounter = regexp.MustCompile(`\s*[(\[]\d+\+?[)\]]\s*$`)
+	whitespace      = regexp.MustCompile(`\s+`)
+)
+
+// Step - result of a single stage of the pipeline
+type Step struct {
+	Name  string
+	Title string
+}
+
+type stage struct {
+	name  string
+	apply func(string) string
+}
+
+type Normalizer struct {
+	stages []stage
+}
+
+// NewNormalizer builds the pipeline described by cfg, the patterns are
+// validated by config.Validate
+func NewNormalizer(cfg config.Normalization) (*Normalizer, error) {
+	n := &Normalizer{}
+
+	if cfg.StripCounters {
+		n.stages = append(n.stages, stage{"strip_counters", stripCounters})
+	}
+
+	if cfg.StripAppSuffixes || len(cfg.AppSuffixes) > 0 {
+		suffixes := slices.Clone(cfg.AppSuffixes)
+		if cfg.StripAppSuffixes {
+			suffixes = append(suffixes, defaultAppSuffixes...)
+		}
+		n.stages = append(n.stages, stage{"strip_app_suffixes", suffixStripper(suffixes)})
+	}
+
+	for _, r := range cfg.Replacements {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		replace := r.Replace
+		n.stages = append(n.stages, stage{
+			name: "replace " + r.Pattern,
+			apply: func(title string) string {
+				return re.ReplaceAllString(title, replace)
+			},
+		})
+	}
+
+	if cfg.CollapseWhitespace {
+		n.stages = append(n.stages, stage{"collapse_whitespace", collapseWhitespace})
+	}
+
+	return n, nil
+}
+
+// Normalize runs the whole pipeline
+func (n *Normalizer) Normalize(title string) string {
+	for _, s := range n.stages {
+		title = s.apply(title)
+	}
+
+	return title
+}
+
+// Steps runs the pipeline and records the title after every stage
+func (n *Normalizer) Steps(title string) []Step {
+	steps := []Step{}
+	for _, s := range n.stages {
+		title = s.apply(title)
+		steps = append(steps, Step{Name: s.name, Title: title})
+	}
+
+	return steps
+}
+
+func stripCounters(title string) string {
+	title = leadingCounter.ReplaceAllString(title, "")
+	return trailingCounter.ReplaceAllString(title, "")
+}
+
+// suffixStripper removes " — Name", " – Name" and " - Name" at the end
+func suffixStripper(names []string) func(string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+
+	// longer names first, so "Mozilla Firefox Private Browsing" wins
+	// over "Mozilla Firefox"
+	sort.SliceStable(quoted, func(i, j int) bool {
+		return len(quoted[i]) > len(quoted[j])
+	})
+
+	re := regexp.MustCompile(`\s+[—–-]\s+(?:` + strings.Join(quoted, "|") + `)\s*$`)
+
+	return func(title string) string {
+		return re.ReplaceAllString(title, "")
+	}
+}
+
+func collapseWhitespace(title string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(title, " "))
+}